@@ -0,0 +1,19 @@
+package main
+
+import (
+	"server/everydaymuslimappserver/internal/handlers"
+	"time"
+)
+
+//listenForCampaigns checks for scheduled newsletter campaigns every minute and sends them
+func listenForCampaigns() {
+	go func() {
+		for {
+			err := handlers.Repo.SendDueCampaigns(app.CampaignSendRate)
+			if err != nil {
+				errorLog.Println("Error sending campaigns:", err)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,8 +13,12 @@ import (
 	"server/everydaymuslimappserver/internal/driver"
 	"server/everydaymuslimappserver/internal/handlers"
 	"server/everydaymuslimappserver/internal/helpers"
+	"server/everydaymuslimappserver/internal/mailer"
 	"server/everydaymuslimappserver/internal/models"
 	"server/everydaymuslimappserver/internal/render"
+	"server/everydaymuslimappserver/internal/vault"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -42,10 +48,19 @@ func main() {
 
 	defer db.SQL.Close()
 
-	defer close(app.MailChan)
+	ctx, cancel := context.WithCancel(context.Background())
 
-	log.Println("Starting Email listener...")
-	listenForMail()
+	log.Println("Starting Email workers...")
+	mailDone := listenForMail(ctx)
+
+	log.Println("Starting campaign sender...")
+	listenForCampaigns()
+
+	log.Println("Starting reminder scheduler...")
+	listenForReminders()
+
+	log.Println("Re-encrypting session notes with the newest key...")
+	rotateSessionNotes()
 
 	log.Println("Server running on port: ", portNumber)
 	srv := &http.Server{
@@ -61,15 +76,24 @@ func main() {
 		}
 	}()
 
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	signal.Notify(sigChan, os.Kill)
 
 	sig := <-sigChan
 	log.Println("Server terminate request received, gracefully shutting down", sig)
 
-	timeOutCtx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	timeOutCtx, timeOutCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer timeOutCancel()
+
 	srv.Shutdown(timeOutCtx)
+
+	//Let the email workers finish the messages they are sending, anything unsent stays in the outbox
+	cancel()
+	select {
+	case <-mailDone:
+	case <-timeOutCtx.Done():
+	}
 }
 
 func run() (*driver.DB, error) {
@@ -81,10 +105,127 @@ func run() (*driver.DB, error) {
 	gob.Register(models.UserRegistration{})
 	gob.Register(models.CounselingSession{})
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	//IN_PRODUCTION=true turns on secure cookies and makes SIGNING_KEY and NOTES_KEYS required
+	app.InProduction, _ = strconv.ParseBool(os.Getenv("IN_PRODUCTION"))
+
+	app.BaseURL = os.Getenv("BASE_URL")
+	if app.BaseURL == "" {
+		app.BaseURL = "http://localhost:8001"
+	}
 
-	app.InProduction = false
+	app.EmailTemplatePath = "./email-templates"
+
+	app.CampaignSendRate, _ = strconv.Atoi(os.Getenv("CAMPAIGN_SEND_RATE"))
+	if app.CampaignSendRate <= 0 {
+		app.CampaignSendRate = 60
+	}
+
+	sessionMinutes, _ := strconv.Atoi(os.Getenv("SESSION_LENGTH_MINUTES"))
+	if sessionMinutes <= 0 {
+		sessionMinutes = 60
+	}
+	app.SessionLength = time.Duration(sessionMinutes) * time.Minute
+
+	//Clients can cancel or reschedule their booking until this long before it starts
+	cutoffHours, err := strconv.Atoi(os.Getenv("BOOKING_CUTOFF_HOURS"))
+	if err != nil || cutoffHours < 0 {
+		cutoffHours = 24
+	}
+	app.BookingCutoff = time.Duration(cutoffHours) * time.Hour
+
+	//How long before each confirmed session reminders are sent, like "24h,1h"
+	reminderOffsets := os.Getenv("REMINDER_OFFSETS")
+	if reminderOffsets == "" {
+		reminderOffsets = "24h,1h"
+	}
+	for _, offset := range strings.Split(reminderOffsets, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(offset))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid REMINDER_OFFSETS %q", reminderOffsets)
+		}
+		app.ReminderOffsets = append(app.ReminderOffsets, d)
+	}
+
+	app.MailWorkers, _ = strconv.Atoi(os.Getenv("MAIL_WORKERS"))
+	if app.MailWorkers <= 0 {
+		app.MailWorkers = 2
+	}
+
+	app.MailMaxAttempts, _ = strconv.Atoi(os.Getenv("MAIL_MAX_ATTEMPTS"))
+	if app.MailMaxAttempts <= 0 {
+		app.MailMaxAttempts = 8
+	}
+
+	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if smtpPort == 0 {
+		smtpPort = 1025
+	}
+
+	smtpHost := os.Getenv("SMTP_HOST")
+	if smtpHost == "" {
+		smtpHost = "localhost"
+	}
+
+	mailDir := os.Getenv("MAIL_DIR")
+	if mailDir == "" {
+		mailDir = "./tmp/mail"
+	}
+
+	m, err := mailer.New(mailer.Config{
+		Driver:     os.Getenv("MAIL_DRIVER"),
+		Host:       smtpHost,
+		Port:       smtpPort,
+		Username:   os.Getenv("SMTP_USERNAME"),
+		Password:   os.Getenv("SMTP_PASSWORD"),
+		Encryption: os.Getenv("SMTP_ENCRYPTION"),
+		Dir:        mailDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	app.Mailer = m
+
+	app.SigningKey = []byte(os.Getenv("SIGNING_KEY"))
+	if len(app.SigningKey) == 0 {
+		//A random key would break every unsubscribe and manage booking link sent before a restart
+		if app.InProduction {
+			return nil, errors.New("SIGNING_KEY must be set in production")
+		}
+		log.Println("SIGNING_KEY not set, signed links will stop working after a restart")
+		key, err := helpers.RandomToken()
+		if err != nil {
+			return nil, err
+		}
+		app.SigningKey = []byte(key)
+	}
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			app.TrustedProxies = append(app.TrustedProxies, proxy)
+		}
+	}
+
+	notesKeys, err := vault.ParseKeys(os.Getenv("NOTES_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	if len(notesKeys) == 0 {
+		//A random key would make every note written before a restart unreadable
+		if app.InProduction {
+			return nil, errors.New("NOTES_KEYS must be set in production")
+		}
+		log.Println("NOTES_KEYS not set, session notes will be unreadable after a restart")
+		key, err := vault.NewKey()
+		if err != nil {
+			return nil, err
+		}
+		notesKeys, _ = vault.ParseKeys("1:" + key)
+	}
+
+	app.NotesKeyring, err = vault.New(notesKeys)
+	if err != nil {
+		return nil, err
+	}
 
 	//Info log
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -121,6 +262,14 @@ func run() (*driver.DB, error) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
+	etc, err := render.CreateEmailTemplateCache(app.EmailTemplatePath)
+	if err != nil {
+		log.Fatal("Can not create email template cache", err)
+		return nil, err
+	}
+
+	app.EmailTemplateCache = etc
+
 	repo := handlers.NewRepo(&app, db)
 
 	handlers.NewHandlers(repo)
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"server/everydaymuslimappserver/internal/handlers"
 	"server/everydaymuslimappserver/internal/helpers"
+	"strconv"
 
+	"github.com/go-chi/chi"
 	"github.com/justinas/nosurf"
 )
 
@@ -22,6 +25,9 @@ func NoSurf(next http.Handler) http.Handler {
 	fmt.Println("NO SURF")
 	csrfHandler := nosurf.New(next)
 
+	//Mail clients post one-click unsubscribes without a CSRF token, the link is signed instead
+	csrfHandler.ExemptPath("/newsletter/unsubscribe")
+
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -48,3 +54,37 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+//Admin only lets users with admin access through. It runs after Auth
+func Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin, err := handlers.Repo.IsAdmin(r)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !admin {
+			session.Put(r.Context(), "error", "Only admins can open that page")
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//CountViews records a view of the contentType item in the URL each time it is shown.
+//Only ids that exist are counted, so made up URLs do not fill the views table
+func CountViews(contentType string, exists func(id int) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			id, err := strconv.Atoi(chi.URLParam(r, "id"))
+			if err != nil || !exists(id) {
+				return
+			}
+
+			handlers.Repo.RecordContentView(contentType, strconv.Itoa(id))
+		})
+	}
+}
@@ -2,8 +2,19 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"server/everydaymuslimappserver/internal/config"
+	"server/everydaymuslimappserver/internal/handlers"
+	"server/everydaymuslimappserver/internal/repository"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -29,3 +40,79 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("Type is not http.Handler, it is %t", v))
 	}
 }
+
+//viewRecorder is a test repository that keeps the content views it is asked to record
+type viewRecorder struct {
+	repository.DatabaseRepo
+	views []string
+}
+
+//RecordContentView keeps the view instead of saving it
+func (v *viewRecorder) RecordContentView(contentType, contentID string, day time.Time) error {
+	v.views = append(v.views, contentType+":"+contentID)
+	return nil
+}
+
+func TestCountViews(t *testing.T) {
+	repo := handlers.NewTestRepo(&config.AppConfig{ErrorLog: log.New(io.Discard, "", 0)})
+	recorder := &viewRecorder{DatabaseRepo: repo.DB}
+	repo.DB = recorder
+	handlers.NewHandlers(repo)
+
+	mux := chi.NewRouter()
+	mux.With(CountViews("hadith", func(id int) bool { return id < 2 })).Get("/hadiths/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hadith"))
+	})
+
+	var tests = []struct {
+		name     string
+		url      string
+		expected []string
+	}{
+		{"existing hadith", "/hadiths/1", []string{"hadith:1"}},
+		{"leading zero", "/hadiths/01", []string{"hadith:1"}},
+		{"not a number", "/hadiths/abc", nil},
+		{"unknown hadith", "/hadiths/7", nil},
+	}
+
+	for _, tt := range tests {
+		recorder.views = nil
+
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !reflect.DeepEqual(recorder.views, tt.expected) {
+			t.Errorf("%s: expected views %v but got %v", tt.name, tt.expected, recorder.views)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	session = scs.New()
+	repo := handlers.NewTestRepo(&config.AppConfig{Session: session})
+	handlers.NewHandlers(repo)
+
+	var tests = []struct {
+		name       string
+		userID     int
+		statusCode int
+	}{
+		{"admin", 1, http.StatusOK},
+		{"counselor", 2, http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		userID := tt.userID
+		h := session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session.Put(r.Context(), "userId", userID)
+			Admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		}))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/dashboard", nil))
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"server/everydaymuslimappserver/internal/handlers"
+)
+
+//rotateSessionNotes encrypts the session notes still using an older key again with the newest one
+func rotateSessionNotes() {
+	go func() {
+		n, err := handlers.Repo.RotateSessionNotes()
+		if err != nil {
+			errorLog.Println("Error re-encrypting session notes:", err)
+		}
+		if n > 0 {
+			infoLog.Println("Re-encrypted", n, "session notes")
+		}
+	}()
+}
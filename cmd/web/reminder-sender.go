@@ -0,0 +1,19 @@
+package main
+
+import (
+	"server/everydaymuslimappserver/internal/handlers"
+	"time"
+)
+
+//listenForReminders queues the counseling session reminders as they fall due
+func listenForReminders() {
+	go func() {
+		for {
+			err := handlers.Repo.SendDueReminders(app.ReminderOffsets)
+			if err != nil {
+				errorLog.Println("Error sending reminders:", err)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
@@ -26,16 +26,16 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/about", handlers.Repo.About)
 
 	mux.Get("/hadiths", hadithHandler.GetHadith)
-	mux.Get("/hadiths/{id}", hadithHandler.GetHadith)
+	mux.With(CountViews("hadith", hadithHandler.Exists)).Get("/hadiths/{id}", hadithHandler.GetHadith)
 
 	mux.Get("/ayahs", ayahHandler.GetAyahs)
-	mux.Get("/ayahs/{id}", ayahHandler.GetAyahs)
+	mux.With(CountViews("ayah", ayahHandler.Exists)).Get("/ayahs/{id}", ayahHandler.GetAyahs)
 
 	mux.Get("/duas", duaHandler.GetDuas)
-	mux.Get("/duas/{id}", duaHandler.GetDuas)
+	mux.With(CountViews("dua", duaHandler.Exists)).Get("/duas/{id}", duaHandler.GetDuas)
 
 	mux.Get("/surahs", surahHandler.GetSurahs)
-	mux.Get("/surahs/{id}", surahHandler.GetSurahs)
+	mux.With(CountViews("surah", surahHandler.Exists)).Get("/surahs/{id}", surahHandler.GetSurahs)
 
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
 
@@ -43,6 +43,9 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/signup", handlers.Repo.PostNewsLetterSignUp)
 
 	mux.Get("/signup-success", handlers.Repo.SignupSuccess)
+	mux.Get("/newsletter/confirm", handlers.Repo.ConfirmNewsletter)
+	mux.Get("/newsletter/unsubscribe", handlers.Repo.NewsletterUnsubscribe)
+	mux.Post("/newsletter/unsubscribe", handlers.Repo.PostNewsletterUnsubscribe)
 
 	mux.Get("/create-user", handlers.Repo.UserRegistration)
 	mux.Post("/create-user", handlers.Repo.PostUserRegistration)
@@ -56,20 +59,91 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/counseling-reservation", handlers.Repo.CounselingSessionRegistration)
 
 	mux.Post("/make-session-reservation", handlers.Repo.PostCounselingReservation)
+	mux.Get("/counseling-reservation-success", handlers.Repo.CounselingReservationSuccess)
+
+	mux.Get("/bookings/manage", handlers.Repo.ManageBooking)
+	mux.Post("/bookings/manage/cancel", handlers.Repo.PostManageBookingCancel)
+	mux.Post("/bookings/manage/reschedule", handlers.Repo.PostManageBookingReschedule)
 	mux.Get("/logout", handlers.Repo.Logout)
 
+	mux.Route("/account", func(mux chi.Router) {
+		mux.Use(Auth)
+		mux.Get("/", handlers.Repo.Account)
+		mux.Get("/export", handlers.Repo.AccountExport)
+		mux.Post("/delete", handlers.Repo.PostAccountDelete)
+
+		mux.Get("/schedule", handlers.Repo.CounselorSchedule)
+		mux.Post("/schedule", handlers.Repo.PostCounselorSchedule)
+		mux.Post("/schedule/exceptions", handlers.Repo.PostCounselorScheduleException)
+		mux.Post("/schedule/exceptions/{eid}/delete", handlers.Repo.PostDeleteCounselorScheduleException)
+
+		mux.Get("/sessions", handlers.Repo.CounselorSessions)
+		mux.Get("/sessions/{id}", handlers.Repo.CounselorShowSession)
+		mux.Post("/sessions/{id}/notes", handlers.Repo.CounselorPostSessionNote)
+	})
+
 	// mux.Get("/admin/dashboard", handlers.Repo.AdminDashboard)
 	mux.Route("/admin", func(mux chi.Router) {
 
 		mux.Use(Auth)
+		mux.Use(Admin)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/api/dashboard", handlers.Repo.AdminDashboardJSON)
 		mux.Get("/all-reservations", handlers.Repo.AdminAllReservations)
 		mux.Get("/new-reservations", handlers.Repo.AdminNewReservations)
-		mux.Get("/calender", handlers.Repo.AdminReservationsCalendar)
+		mux.Get("/calendar", handlers.Repo.AdminReservationsCalendar)
+		mux.Post("/calendar/blocks", handlers.Repo.AdminPostCalendarBlock)
+		mux.Post("/calendar/blocks/{id}/delete", handlers.Repo.AdminPostDeleteCalendarBlock)
+		mux.Get("/api/reservations", handlers.Repo.AdminReservationsJSON)
 
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
+		mux.Get("/reservations/export", handlers.Repo.AdminReservationsExport)
+		mux.Get("/reservations/report", handlers.Repo.AdminReservationsReport)
 		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+		mux.Post("/reservations/{src}/{id}/counselor", handlers.Repo.AdminPostReservationCounselor)
+
+		mux.Get("/counselors", handlers.Repo.AdminCounselors)
+		mux.Get("/counselors/new", handlers.Repo.AdminNewCounselor)
+		mux.Post("/counselors/new", handlers.Repo.AdminPostNewCounselor)
+		mux.Get("/counselors/{id}", handlers.Repo.AdminShowCounselor)
+		mux.Post("/counselors/{id}", handlers.Repo.AdminPostShowCounselor)
+		mux.Post("/counselors/{id}/exceptions", handlers.Repo.AdminPostCounselorException)
+		mux.Post("/counselors/{id}/exceptions/{eid}/delete", handlers.Repo.AdminPostDeleteCounselorException)
+
+		mux.Get("/intake", handlers.Repo.AdminIntakeQuestions)
+		mux.Get("/intake/new", handlers.Repo.AdminNewIntakeQuestion)
+		mux.Post("/intake/new", handlers.Repo.AdminPostNewIntakeQuestion)
+		mux.Get("/intake/{id}", handlers.Repo.AdminShowIntakeQuestion)
+		mux.Post("/intake/{id}", handlers.Repo.AdminPostShowIntakeQuestion)
+
+		mux.Get("/privacy", handlers.Repo.AdminPrivacy)
+		mux.Post("/privacy/export", handlers.Repo.AdminPostPrivacyExport)
+		mux.Post("/privacy/delete", handlers.Repo.AdminPostPrivacyDelete)
+
+		mux.Get("/subscribers", handlers.Repo.AdminSubscribers)
+		mux.Get("/subscribers/export", handlers.Repo.AdminSubscribersExport)
+		mux.Post("/subscribers/import", handlers.Repo.AdminPostSubscribersImport)
+		mux.Post("/subscribers/tags", handlers.Repo.AdminPostSubscriberTags)
+		mux.Post("/subscribers/tag", handlers.Repo.AdminPostSubscribersTag)
+
+		mux.Get("/campaigns", handlers.Repo.AdminCampaigns)
+		mux.Get("/campaigns/new", handlers.Repo.AdminNewCampaign)
+		mux.Post("/campaigns/new", handlers.Repo.AdminPostNewCampaign)
+		mux.Get("/campaigns/{id}", handlers.Repo.AdminShowCampaign)
+		mux.Post("/campaigns/{id}", handlers.Repo.AdminPostShowCampaign)
+		mux.Get("/campaigns/{id}/preview", handlers.Repo.AdminPreviewCampaign)
+		mux.Get("/campaigns/{id}/cancel", handlers.Repo.AdminCancelCampaign)
+
+		mux.Get("/outbox", handlers.Repo.AdminOutbox)
+		mux.Get("/outbox/{id}/retry", handlers.Repo.AdminRetryOutboxEmail)
+
+		mux.Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		mux.Get("/email-templates/{name}", handlers.Repo.AdminPreviewEmailTemplate)
+		mux.Post("/email-templates/{name}/send", handlers.Repo.AdminPostSendTestEmail)
+
+		mux.Get("/audit", handlers.Repo.AdminAudit)
+		mux.Get("/audit/export", handlers.Repo.AdminAuditExport)
 
 	})
 	mux.Get("/*", handlers.Repo.DoesNotExistPage)
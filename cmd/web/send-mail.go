@@ -1,63 +1,28 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"log"
-	"server/everydaymuslimappserver/internal/models"
-	"strings"
-	"time"
-
-	mail "github.com/xhit/go-simple-mail/v2"
+	"context"
+	"server/everydaymuslimappserver/internal/handlers"
+	"server/everydaymuslimappserver/internal/outbox"
 )
 
-func listenForMail() {
-	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
-	}()
-}
-
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
-
-	server.Host = "localhost"
-
-	server.Port = 1025
-	server.KeepAlive = false
-
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
+//listenForMail starts the workers that send emails from the outbox.
+//The returned channel is closed once ctx is cancelled and the workers have stopped
+func listenForMail(ctx context.Context) <-chan struct{} {
+	pool := &outbox.Pool{
+		Store:       handlers.Repo.DB,
+		Mailer:      app.Mailer,
+		Workers:     app.MailWorkers,
+		MaxAttempts: app.MailMaxAttempts,
+		InfoLog:     infoLog,
+		ErrorLog:    errorLog,
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-
-		if err != nil {
-			app.ErrorLog.Println(err)
-		}
-
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
-	}
-
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email Sent")
-	}
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(done)
+	}()
 
+	return done
 }
@@ -3,7 +3,9 @@ package config
 import (
 	"html/template"
 	"log"
-	"server/everydaymuslimappserver/internal/models"
+	"server/everydaymuslimappserver/internal/mailer"
+	"server/everydaymuslimappserver/internal/vault"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -16,5 +18,18 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	Mailer        mailer.Mailer
+	BaseURL       string
+	SigningKey    []byte
+	NotesKeyring  *vault.Keyring
+
+	EmailTemplatePath  string
+	EmailTemplateCache map[string]*template.Template
+	CampaignSendRate   int
+	MailWorkers        int
+	MailMaxAttempts    int
+	SessionLength      time.Duration
+	BookingCutoff      time.Duration
+	ReminderOffsets    []time.Duration
+	TrustedProxies     []string
 }
@@ -1,24 +1,36 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"server/everydaymuslimappserver/internal/config"
 	"server/everydaymuslimappserver/internal/driver"
 	"server/everydaymuslimappserver/internal/forms"
 	"server/everydaymuslimappserver/internal/helpers"
+	"server/everydaymuslimappserver/internal/ics"
+	"server/everydaymuslimappserver/internal/matching"
 	"server/everydaymuslimappserver/internal/models"
 	"server/everydaymuslimappserver/internal/render"
 	"server/everydaymuslimappserver/internal/repository"
 	"server/everydaymuslimappserver/internal/repository/dbrepo"
+	"server/everydaymuslimappserver/internal/vault"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/asaskevich/govalidator"
 	"github.com/go-chi/chi"
 	"github.com/hablullah/go-hijri"
 )
@@ -43,6 +55,7 @@ func NewRepo(app *config.AppConfig, db *driver.DB) *Repository {
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
 		App: a,
+		DB:  dbrepo.NewTestingRepo(a),
 	}
 }
 
@@ -157,11 +170,12 @@ type Surah struct {
 
 //jsonResponse struct
 type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	Date      string `json:"Date"`
-	StartTime string `json:"startTime"`
-	EndTime   string `json:"endTime"`
+	OK        bool          `json:"ok"`
+	Message   string        `json:"message"`
+	Date      string        `json:"Date"`
+	StartTime string        `json:"startTime"`
+	EndTime   string        `json:"endTime"`
+	Slots     []models.Slot `json:"slots,omitempty"`
 }
 
 //Hadiths type
@@ -194,10 +208,12 @@ type Ayah struct {
 	Text string `json:"Text"`
 }
 type ayahHandlers struct {
+	sync.Mutex
 	ayahs Ayahs
 }
 
 type surahHandlers struct {
+	sync.Mutex
 	surahs Surahs
 }
 
@@ -294,6 +310,34 @@ func NewDuaHandlers() *duaHandlers {
 	}
 }
 
+//Exists reports whether there is a hadith with id
+func (h *hadithHandlers) Exists(id int) bool {
+	h.Lock()
+	defer h.Unlock()
+	return id >= 0 && id < len(h.hadiths)
+}
+
+//Exists reports whether there is an ayah with id
+func (h *ayahHandlers) Exists(id int) bool {
+	h.Lock()
+	defer h.Unlock()
+	return id >= 0 && id < len(h.ayahs)
+}
+
+//Exists reports whether there is a dua with id
+func (h *duaHandlers) Exists(id int) bool {
+	h.Lock()
+	defer h.Unlock()
+	return id >= 0 && id < len(h.duas)
+}
+
+//Exists reports whether there is a surah with id
+func (s *surahHandlers) Exists(id int) bool {
+	s.Lock()
+	defer s.Unlock()
+	return id >= 0 && id < len(s.surahs)
+}
+
 //idFromUrl returns the id from the req.params
 func idFromUrl(r *http.Request) (int, error) {
 	parts := strings.Split(r.URL.String(), "/")
@@ -340,47 +384,83 @@ func respondWithJSON(w http.ResponseWriter, code int, data interface{}) {
 	w.Write(jsonBytes)
 }
 
-//AvailabilityJSON return JSON for available time slots
+//AvailabilityJSON returns the free counseling slots on date between start-time and end-time as JSON.
+//counseling-session-id limits the search to one counselor
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		resp := jsonResponse{
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
 			OK:      false,
 			Message: "Internal Server Error",
-		}
-		out, _ := json.MarshalIndent(resp, "", "/t")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		})
+		return
+	}
+
+	date := r.Form.Get("date")
+	startTime := r.Form.Get("start-time")
+	endTime := r.Form.Get("end-time")
+
+	start, startErr := time.ParseInLocation("2006-01-02 15:04", date+" "+startTime, time.Local)
+	end, endErr := time.ParseInLocation("2006-01-02 15:04", date+" "+endTime, time.Local)
+	if startErr != nil || endErr != nil || !end.After(start) {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: "Please choose a date and a start time before the end time",
+		})
+		return
+	}
+
+	now := time.Now()
+	if !end.After(now) {
+		writeJSON(w, http.StatusBadRequest, jsonResponse{
+			OK:      false,
+			Message: pastStartMessage,
+		})
 		return
 	}
 
-	//TODO: change to search DB for available times
-	available := true
+	//Times that have already passed today are left out, starting from the next full hour
+	if start.Before(now) {
+		start = now.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	counselingSessionID, _ := strconv.Atoi(r.Form.Get("counseling-session-id"))
 
+	slots, err := m.DB.SearchAvailability(start, end, m.App.SessionLength, counselingSessionID)
 	if err != nil {
-		resp := jsonResponse{
+		m.App.ErrorLog.Println(err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
 			OK:      false,
 			Message: "Error connecting to Database",
-		}
-		out, _ := json.MarshalIndent(resp, "", "/t")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		})
 		return
 	}
 
 	resp := jsonResponse{
-		//TODO: Add available times
-		OK:      available,
-		Message: "This time is available!",
+		OK:        len(slots) > 0,
+		Message:   "This time is available!",
+		Date:      date,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Slots:     slots,
+	}
+
+	if !resp.OK {
+		resp.Message = "No sessions are free at this time"
+		resp.Slots = []models.Slot{}
 	}
 
+	writeJSON(w, http.StatusOK, resp)
+}
+
+//writeJSON writes resp as indented JSON
+func writeJSON(w http.ResponseWriter, code int, resp interface{}) {
 	//Manually construct JSON--Won't throw an error
 	out, _ := json.MarshalIndent(resp, "", "     ")
 
 	w.Header().Set("Content-Type", "application/json")
-
+	w.WriteHeader(code)
 	w.Write(out)
-
 }
 
 func (m *Repository) SignupSuccess(w http.ResponseWriter, r *http.Request) {
@@ -468,33 +548,73 @@ func (m *Repository) PostNewsLetterSignUp(w http.ResponseWriter, r *http.Request
 
 	}
 
-	var user models.User
-	user.FirstName = r.Form.Get("first-name")
-	user.LastName = r.Form.Get("last-name")
-	user.Email = r.Form.Get("email")
+	subscriber, err := m.DB.GetNewsletterSubscriberByEmail(signup.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	log.Print(user)
-	//TODO: CreateUserInDB(w, r, user)
-	htmlMessage := fmt.Sprintf(`
-		<strong>Thank You for Signing Up</strong><br>
-		Dear %s %s, <br>
-		You have successfully signed up for our bimonthly newsletter and email list for app updates.
-		Please watch your inbox for our newsletters.  
-		JazakAllahu Khairun
+	if subscriber.Status == models.SubscriberConfirmed {
+		form.Errors.Add("email", "This email address is already subscribed to our newsletter")
+		data := make(map[string]interface{})
+		data["signup"] = signup
+		render.Templates(w, r, "signup.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	token, err := helpers.RandomToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	`, signup.FirstName, signup.LastName)
+	subscriber.FirstName = signup.FirstName
+	subscriber.LastName = signup.LastName
+	subscriber.Email = signup.Email
+	subscriber.Status = models.SubscriberPending
+	subscriber.Token = token
+
+	//Pending and unsubscribed addresses get a fresh confirmation link
+	if subscriber.ID == 0 {
+		subscriber.ID, err = m.DB.InsertNewsletterSubscriber(subscriber)
+	} else {
+		err = m.DB.UpdateNewsletterSubscriber(subscriber)
+	}
 
-	msg := models.MailData{
-		To:       signup.Email,
-		From:     "productivedailymuslim@aaaaaaa.com",
-		Subject:  "Newsletter Signup Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	if helpers.Status(err) == http.StatusConflict {
+		form.Errors.Add("email", "This email address has already signed up, please check your inbox")
+		data := make(map[string]interface{})
+		data["signup"] = signup
+		render.Templates(w, r, "signup.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.MailChan <- msg
+	confirmLink := fmt.Sprintf("%s/newsletter/confirm?token=%s", m.App.BaseURL, token)
 
-	//Send email to User and Admin
+	msg, err := m.newsletterMail(subscriber, "Please Confirm your Newsletter Signup", "newsletter-confirm.email.html",
+		models.NewsletterConfirmEmail{
+			EmailData:   m.newsletterEmailData(subscriber),
+			ConfirmLink: confirmLink,
+		})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.queueMail(msg)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	//Add session
 	m.App.Session.Put(r.Context(), "signup", signup)
@@ -502,6 +622,172 @@ func (m *Repository) PostNewsLetterSignUp(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, "/signup-success", http.StatusSeeOther)
 }
 
+//ConfirmNewsletter confirms a newsletter subscription from the link in the confirmation email
+func (m *Repository) ConfirmNewsletter(w http.ResponseWriter, r *http.Request) {
+	subscriber, err := m.DB.ConfirmNewsletterSubscriber(r.URL.Query().Get("token"))
+	if helpers.Status(err) == http.StatusNotFound {
+		m.App.Session.Put(r.Context(), "error", "This confirmation link is invalid or has already been used")
+		http.Redirect(w, r, "/signup", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["subscriber"] = subscriber
+
+	render.Templates(w, r, "newsletter-confirmed.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//unsubscribeLink returns the signed one-click unsubscribe link for a subscriber
+func (m *Repository) unsubscribeLink(subscriberID int) string {
+	id := strconv.Itoa(subscriberID)
+	return fmt.Sprintf("%s/newsletter/unsubscribe?id=%s&sig=%s",
+		m.App.BaseURL, id, helpers.Sign("unsubscribe:"+id))
+}
+
+//queueMail adds an email to the outbox, the mail workers send it in the background
+func (m *Repository) queueMail(msg models.MailData) error {
+	_, err := m.DB.InsertOutboxEmail(msg)
+	if err != nil {
+		m.App.ErrorLog.Println("Error queueing email to", msg.To, err)
+		return err
+	}
+	return nil
+}
+
+//renderMail builds an email from one of the templates in email-templates
+func (m *Repository) renderMail(to, subject, tmpl string, data interface{}) (models.MailData, error) {
+	htmlMessage, textMessage, err := render.Email(tmpl, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:          to,
+		From:        "productivedailymuslim@aaaaaaa.com",
+		Subject:     subject,
+		Content:     htmlMessage,
+		TextContent: textMessage,
+		Template:    tmpl,
+	}, nil
+}
+
+//newsletterEmailData returns the template data for an email to a newsletter subscriber, including their unsubscribe link
+func (m *Repository) newsletterEmailData(subscriber models.NewsletterSubscriber) models.EmailData {
+	return models.EmailData{
+		FirstName:       subscriber.FirstName,
+		LastName:        subscriber.LastName,
+		BaseURL:         m.App.BaseURL,
+		UnsubscribeLink: m.unsubscribeLink(subscriber.ID),
+	}
+}
+
+//newsletterMail builds a newsletter email with RFC 8058 List-Unsubscribe headers.
+//The template shows the unsubscribe link from newsletterEmailData in its footer
+func (m *Repository) newsletterMail(subscriber models.NewsletterSubscriber, subject, tmpl string, data interface{}) (models.MailData, error) {
+	msg, err := m.renderMail(subscriber.Email, subject, tmpl, data)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Headers = map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", m.unsubscribeLink(subscriber.ID)),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+
+	return msg, nil
+}
+
+//subscriberFromUnsubscribeLink gets the subscriber named in a signed unsubscribe link
+func (m *Repository) subscriberFromUnsubscribeLink(r *http.Request) (models.NewsletterSubscriber, error) {
+	id := r.FormValue("id")
+	if !helpers.ValidSignature("unsubscribe:"+id, r.FormValue("sig")) {
+		return models.NewsletterSubscriber{}, helpers.NewBadRequest("invalid unsubscribe link")
+	}
+
+	subscriberID, err := strconv.Atoi(id)
+	if err != nil {
+		return models.NewsletterSubscriber{}, helpers.NewBadRequest("invalid unsubscribe link")
+	}
+
+	return m.DB.GetNewsletterSubscriberByID(subscriberID)
+}
+
+//NewsletterUnsubscribe asks the subscriber to confirm they want to unsubscribe
+func (m *Repository) NewsletterUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	subscriber, err := m.subscriberFromUnsubscribeLink(r)
+	if helpers.Status(err) == http.StatusBadRequest || errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This unsubscribe link is invalid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = r.URL.Query().Get("id")
+	stringMap["sig"] = r.URL.Query().Get("sig")
+
+	data := make(map[string]interface{})
+	data["subscriber"] = subscriber
+
+	render.Templates(w, r, "newsletter-unsubscribe.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//PostNewsletterUnsubscribe unsubscribes a subscriber. It handles both the form on the
+//unsubscribe page and RFC 8058 one-click requests sent by mail clients
+func (m *Repository) PostNewsletterUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	subscriber, err := m.subscriberFromUnsubscribeLink(r)
+	if helpers.Status(err) == http.StatusBadRequest || errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if subscriber.Status != models.SubscriberUnsubscribed {
+		before := subscriber.Status
+		subscriber.Status = models.SubscriberUnsubscribed
+		subscriber.UnsubscribedAt = time.Now()
+
+		err = m.DB.UpdateNewsletterSubscriber(subscriber)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.audit(r, "newsletter.unsubscribe", fmt.Sprintf("subscriber:%d", subscriber.ID),
+			map[string]string{"status": before}, map[string]string{"status": subscriber.Status})
+	}
+
+	if r.PostForm.Get("List-Unsubscribe") == "One-Click" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["subscriber"] = subscriber
+
+	render.Templates(w, r, "newsletter-unsubscribed.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
 //UserRegstration allosw users to register an account
 func (m *Repository) UserRegistration(w http.ResponseWriter, r *http.Request) {
 	var emptySignupForm models.UserRegistration
@@ -564,25 +850,22 @@ func (m *Repository) PostUserRegistration(w http.ResponseWriter, r *http.Request
 	log.Print(newUser)
 	//TODO: CreateUserInDB(w, r, user)
 	//TODO: change url on signup link
-	htmlMessage := fmt.Sprintf(`
-		<strong>Thank You for Registering your account with the Productive Muslim App</strong><br>
-		Dear %s %s, <br>
-		You have successfully signed up for the productive Muslim app. May it bring you many rewards
-		and benefit.
-		Please consider signing up for our newsletters. https://localhost:8001/signup  
-		JazakAllahu Khairun
-
-	`, signup.FirstName, signup.LastName)
-
-	msg := models.MailData{
-		To:       signup.Email,
-		From:     "productivedailymuslim@aaaaaaa.com",
-		Subject:  "Newsletter Signup Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	msg, err := m.renderMail(signup.Email, "Newsletter Signup Confirmation", "account-created.email.html",
+		models.EmailData{
+			FirstName: signup.FirstName,
+			LastName:  signup.LastName,
+			BaseURL:   m.App.BaseURL,
+		})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.MailChan <- msg
+	err = m.queueMail(msg)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	//Send email to User and Admin
 
@@ -592,19 +875,86 @@ func (m *Repository) PostUserRegistration(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, "/user-created-success", http.StatusSeeOther)
 }
 
-//CouncilingSessionRegistration allows users to register an account
+//CounselingSessionRegistration shows the counseling request form, where people pick a free slot
+//and answer the intake questions
 func (m *Repository) CounselingSessionRegistration(w http.ResponseWriter, r *http.Request) {
-	var emptySignupForm models.UserRegistration
+	questions, err := m.DB.IntakeQuestions(true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	data := make(map[string]interface{})
-	data["user-signup"] = emptySignupForm
+	m.renderCounselingRegistration(w, r, models.CounselingRegistration{}, questions, forms.New(nil))
+}
 
-	render.Templates(w, r, "counciling-registration.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
-	})
+//intakeAnswers checks the posted answers to the intake questions, adding form errors for any
+//that are missing or not one of the options
+func intakeAnswers(questions []models.IntakeQuestion, form *forms.Form) []models.IntakeAnswer {
+	var answers []models.IntakeAnswer
+
+	for _, q := range questions {
+		field := "intake-" + q.Key
+		answer := strings.TrimSpace(form.Get(field))
+
+		if answer == "" {
+			if q.Required {
+				form.Errors.Add(field, "Please answer this question")
+			}
+			continue
+		}
+
+		switch q.Kind {
+		case models.IntakeChoice:
+			found := false
+			for _, option := range q.Options {
+				if option == answer {
+					found = true
+				}
+			}
+			if !found {
+				form.Errors.Add(field, "Please choose one of the options")
+				continue
+			}
+		case models.IntakeYesNo:
+			if answer != "yes" && answer != "no" {
+				form.Errors.Add(field, "Please answer yes or no")
+				continue
+			}
+		default:
+			if len(answer) > 2000 {
+				form.Errors.Add(field, "Please keep this under 2000 characters")
+				continue
+			}
+		}
+
+		answers = append(answers, models.IntakeAnswer{
+			QuestionID:    q.ID,
+			QuestionKey:   q.Key,
+			QuestionLabel: q.Label,
+			Answer:        answer,
+		})
+	}
+
+	return answers
 }
 
+//slotTakenMessage is shown when someone else books a slot while it is being picked
+const slotTakenMessage = "Sorry, that slot was just taken. Please choose another time"
+
+//noMatchMessage is shown when counselors are free but none fits the client's preferences
+const noMatchMessage = "Sorry, no counselor who fits your preferences is free then. Please choose another time"
+
+//firstAvailableStart is posted as the start when the client takes the first time a matching counselor is free
+const firstAvailableStart = "first-available"
+
+//pastStartMessage is shown when a time that has already passed is picked
+const pastStartMessage = "Please choose a time in the future"
+
+//matchSearchDays is how many days ahead the first available session is looked for
+const matchSearchDays = 14
+
+//PostCounselingReservation books the time picked on the counseling request form with the counselor
+//who best fits the client's intake answers
 func (m *Repository) PostCounselingReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 
@@ -620,10 +970,11 @@ func (m *Repository) PostCounselingReservation(w http.ResponseWriter, r *http.Re
 		Gender:    r.Form.Get("gender"),
 	}
 
-	log.Print("counseling data from registration form")
-	log.Print(signup.FirstName)
-	log.Print(signup.LastName)
-	log.Print(signup.Email)
+	questions, err := m.DB.IntakeQuestions(true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	form := forms.New(r.PostForm)
 
@@ -635,87 +986,502 @@ func (m *Repository) PostCounselingReservation(w http.ResponseWriter, r *http.Re
 
 	form.IsEmail("email")
 
-	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["user-signup"] = signup
-		render.Templates(w, r, "counceling-signup.page.html", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
-		return
+	if signup.Gender != "" && signup.Gender != "male" && signup.Gender != "female" {
+		form.Errors.Add("gender", "Please choose male or female")
+	}
 
+	answers := intakeAnswers(questions, form)
+
+	//A zero start asks for the first time a matching counselor is free
+	var start time.Time
+	if r.Form.Get("start") != firstAvailableStart {
+		start, err = time.Parse(time.RFC3339, r.Form.Get("start"))
+		if err != nil {
+			form.Errors.Add("start", "Choose one of the available times")
+		} else if !start.After(time.Now()) {
+			form.Errors.Add("start", pastStartMessage)
+		}
+		start = start.In(time.Local)
 	}
 
-	var newSession models.CounselingRegistration
-	newSession.FirstName = r.Form.Get("first-name")
-	newSession.LastName = r.Form.Get("last-name")
-	newSession.Email = r.Form.Get("email")
+	//The client's timezone comes from their browser and is only used to show times in reminders
+	timezone := r.Form.Get("timezone")
+	if _, err := time.LoadLocation(timezone); err != nil {
+		timezone = ""
+	}
 
-	log.Print(newSession)
-	//TODO: CreateCouncelingRegistrationInDB(w, r, user)
-	//TODO: change url on signup link
-	htmlMessage := fmt.Sprintf(`
-		<strong>Thank You for Requesting a counseling session</strong><br>
-		Dear %s %s, <br>
-		You have successfully signed up for a session. 
-		Someone will be contacting you shortly about the time and link for the session.
-		May it bring you many rewards
-		and benefit.
-		Please consider signing up for our newsletters. https://localhost:8001/signup  
-		JazakAllahu Khairun
+	res := models.Reservation{
+		FirstName: signup.FirstName,
+		LastName:  signup.LastName,
+		Email:     signup.Email,
+		Timezone:  timezone,
+		Gender:    signup.Gender,
+		Answers:   answers,
+	}
 
-	`, signup.FirstName, signup.LastName)
+	var match matching.Match
+	if form.Valid() {
+		candidates, err := m.matchCandidates(start)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	msg := models.MailData{
-		To:       signup.Email,
-		From:     "productivedailymuslim@aaaaaaa.com",
-		Subject:  "Newsletter Signup Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+		matches := matching.Rank(matching.NewRequest(res), candidates)
+		if len(candidates) == 0 {
+			form.Errors.Add("start", slotTakenMessage)
+		} else if len(matches) == 0 {
+			form.Errors.Add("start", noMatchMessage)
+		} else {
+			match = matches[0]
+		}
 	}
 
-	m.App.MailChan <- msg
+	if !form.Valid() {
+		m.renderCounselingRegistration(w, r, signup, questions, form)
+		return
+	}
 
-	//Send email to User and Admin
+	res.Date = match.Slot.StartTime
+	res.StartTime = match.Slot.StartTime
+	res.EndTime = match.Slot.EndTime
+	res.CounselingSessionID = match.Counselor.ID
+	res.CounselingSession = match.Counselor
 
-	//Add session
-	m.App.Session.Put(r.Context(), "counseling-signup", signup)
+	res.ID, err = m.DB.InsertReservation(res)
+	if helpers.Status(err) == http.StatusConflict {
+		form.Errors.Add("start", slotTakenMessage)
+		m.renderCounselingRegistration(w, r, signup, questions, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	http.Redirect(w, r, "/counceling-signup-success", http.StatusSeeOther)
-}
+	m.audit(r, "reservation.auto_assign", fmt.Sprintf("reservation:%d", res.ID), nil, matchSummary(match))
 
-//Logout logs a user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+	//The booking is made even if the email can not be queued, so only log the error
+	msg, err := m.counselingRequestMail(res)
+	if err == nil {
+		err = m.queueMail(msg)
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("Error sending counseling request email for reservation", res.ID, err)
+	}
 
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
-}
+	m.App.Session.Put(r.Context(), "reservation", res)
 
-//ShowLogin shows the login page
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Templates(w, r, "login.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+	http.Redirect(w, r, "/counseling-reservation-success", http.StatusSeeOther)
 }
 
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	//Renew token when logging in and out
-	_ = m.App.Session.RenewToken(r.Context())
-
-	err := r.ParseForm()
+//matchCandidates returns the active counselors free at start with their upcoming workload. For a
+//zero start it returns each counselor's earliest free slot in the next matchSearchDays days instead
+func (m *Repository) matchCandidates(start time.Time) ([]matching.Candidate, error) {
+	counselors, err := m.DB.AllCounselors()
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
+	active := make(map[int]models.CounselingSession)
+	for _, c := range counselors {
+		if c.Active {
+			active[c.ID] = c
+		}
+	}
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
+	workloads, err := m.DB.CounselorWorkloads(time.Now())
+	if err != nil {
+		return nil, err
+	}
 
-	log.Println("email ", email, "password: ", password)
+	earliest := make(map[int]models.Slot)
+
+	if !start.IsZero() {
+		slots, err := m.DB.SearchAvailability(start, start.Add(m.App.SessionLength), m.App.SessionLength, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, slot := range slots {
+			if slot.StartTime.Equal(start) {
+				earliest[slot.CounselingSessionID] = slot
+			}
+		}
+	} else {
+		from := time.Now().Truncate(time.Hour).Add(time.Hour)
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+
+		//Availability is searched one day at a time, stopping once every counselor has a slot
+		for i := 0; i < matchSearchDays && len(earliest) < len(active); i++ {
+			dayStart := day.AddDate(0, 0, i)
+			if dayStart.Before(from) {
+				dayStart = from
+			}
+
+			slots, err := m.DB.SearchAvailability(dayStart, day.AddDate(0, 0, i+1), m.App.SessionLength, 0)
+			if err != nil {
+				return nil, err
+			}
+			for _, slot := range slots {
+				if _, ok := earliest[slot.CounselingSessionID]; !ok {
+					earliest[slot.CounselingSessionID] = slot
+				}
+			}
+		}
+	}
+
+	var candidates []matching.Candidate
+	for id, slot := range earliest {
+		c, ok := active[id]
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, matching.Candidate{Counselor: c, Slot: slot, Workload: workloads[id]})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Counselor.ID < candidates[j].Counselor.ID
+	})
+
+	return candidates, nil
+}
+
+//matchSummary is what the audit log keeps about a counselor match
+func matchSummary(match matching.Match) map[string]interface{} {
+	return map[string]interface{}{
+		"counselingSessionId": match.Counselor.ID,
+		"counselor":           match.Counselor.CounselorName,
+		"score":               match.Score,
+		"reasons":             match.Reasons,
+	}
+}
+
+//offeredSlot returns the free slot for a counselor starting at start, if there is one
+func (m *Repository) offeredSlot(counselingSessionID int, start time.Time) (models.Slot, bool, error) {
+	slots, err := m.DB.SearchAvailability(start, start.Add(m.App.SessionLength), m.App.SessionLength, counselingSessionID)
+	if err != nil {
+		return models.Slot{}, false, err
+	}
+
+	for _, slot := range slots {
+		if slot.CounselingSessionID == counselingSessionID && slot.StartTime.Equal(start) {
+			return slot, true, nil
+		}
+	}
+
+	return models.Slot{}, false, nil
+}
+
+//renderCounselingRegistration shows the counseling request form, with its errors once posted
+func (m *Repository) renderCounselingRegistration(w http.ResponseWriter, r *http.Request, signup models.CounselingRegistration,
+	questions []models.IntakeQuestion, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["counseling-reservation"] = signup
+	data["questions"] = questions
+
+	render.Templates(w, r, "counciling-registration.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//counselingRequestMail builds the email telling someone their counseling request was received
+func (m *Repository) counselingRequestMail(res models.Reservation) (models.MailData, error) {
+	return m.renderMail(res.Email, "We received your counseling request", "counseling-request.email.html",
+		models.CounselingSessionEmail{
+			EmailData: models.EmailData{
+				FirstName: res.FirstName,
+				LastName:  res.LastName,
+				BaseURL:   m.App.BaseURL,
+			},
+			Counselor:  res.CounselingSession.CounselorName,
+			Start:      res.StartTime,
+			End:        res.EndTime,
+			ManageLink: m.manageBookingLink(res.ID),
+		})
+}
+
+//CounselingReservationSuccess shows the summary of a counseling reservation that was just made
+func (m *Repository) CounselingReservationSuccess(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.ErrorLog.Println("Could not get reservation from the session")
+		m.App.Session.Put(r.Context(), "error", "Could not get reservation from the session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Remove(r.Context(), "reservation")
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	render.Templates(w, r, "counseling-reservation-success.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//manageBookingLink returns the signed link clients use to cancel or reschedule a reservation
+func (m *Repository) manageBookingLink(reservationID int) string {
+	id := strconv.Itoa(reservationID)
+	return fmt.Sprintf("%s/bookings/manage?id=%s&sig=%s",
+		m.App.BaseURL, id, helpers.Sign("manage-booking:"+id))
+}
+
+//reservationFromManageLink gets the reservation named in a signed manage booking link
+func (m *Repository) reservationFromManageLink(r *http.Request) (models.Reservation, error) {
+	id := r.FormValue("id")
+	if !helpers.ValidSignature("manage-booking:"+id, r.FormValue("sig")) {
+		return models.Reservation{}, helpers.NewBadRequest("invalid manage booking link")
+	}
+
+	reservationID, err := strconv.Atoi(id)
+	if err != nil {
+		return models.Reservation{}, helpers.NewBadRequest("invalid manage booking link")
+	}
+
+	return m.DB.GetReservationByID(reservationID)
+}
+
+//bookingChangeable reports whether a client can still cancel or reschedule a reservation themselves
+func (m *Repository) bookingChangeable(res models.Reservation) bool {
+	start, _ := sessionTimes(res)
+	return models.CanTransitionReservation(res.Status, models.ReservationCancelledByUser) &&
+		time.Until(start) > m.App.BookingCutoff
+}
+
+//manageBookingReservation gets the reservation for the manage booking handlers. It sends the client
+//home when the link is invalid and back to the manage page when the booking can no longer be changed
+func (m *Repository) manageBookingReservation(w http.ResponseWriter, r *http.Request, change bool) (models.Reservation, bool) {
+	res, err := m.reservationFromManageLink(r)
+	if helpers.Status(err) == http.StatusBadRequest || errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This booking link is invalid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	if change && !m.bookingChangeable(res) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf(
+			"This booking can no longer be changed online. Please contact us if you need to change it within %s of the session",
+			durationText(m.App.BookingCutoff)))
+		http.Redirect(w, r, m.manageBookingLink(res.ID), http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+//ManageBooking shows clients their booking from the signed link in their emails,
+//with the options to cancel or reschedule it
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.manageBookingReservation(w, r, false)
+	if !ok {
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = r.FormValue("id")
+	stringMap["sig"] = r.FormValue("sig")
+	stringMap["cutoff"] = durationText(m.App.BookingCutoff)
+	if m.bookingChangeable(res) {
+		stringMap["changeable"] = "1"
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	render.Templates(w, r, "manage-booking.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//PostManageBookingCancel cancels a booking for the client
+func (m *Repository) PostManageBookingCancel(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	res, ok := m.manageBookingReservation(w, r, true)
+	if !ok {
+		return
+	}
+
+	start, end := sessionTimes(res)
+	m.changeBooking(w, r, res, models.ReservationCancelledByUser, start, end, "Your session has been cancelled")
+}
+
+//PostManageBookingReschedule moves a booking to the free slot the client picked
+func (m *Repository) PostManageBookingReschedule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	res, ok := m.manageBookingReservation(w, r, true)
+	if !ok {
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, r.Form.Get("start"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose one of the available times")
+		http.Redirect(w, r, m.manageBookingLink(res.ID), http.StatusSeeOther)
+		return
+	}
+	start = start.In(time.Local)
+
+	slot, ok, err := m.offeredSlot(res.CounselingSessionID, start)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !ok || time.Until(start) <= m.App.BookingCutoff {
+		m.App.Session.Put(r.Context(), "error", slotTakenMessage)
+		http.Redirect(w, r, m.manageBookingLink(res.ID), http.StatusSeeOther)
+		return
+	}
+
+	m.changeBooking(w, r, res, models.ReservationRescheduled, slot.StartTime, slot.EndTime, "Your session has been moved")
+}
+
+//changeBooking moves a client's reservation to status at the given times, then emails
+//the client and lets the counselor know
+func (m *Repository) changeBooking(w http.ResponseWriter, r *http.Request, res models.Reservation, status string,
+	start, end time.Time, flash string) {
+	before := res
+	previousStart, _ := sessionTimes(res)
+
+	err := m.DB.TransitionReservation(models.ReservationStatusChange{
+		ReservationID: res.ID,
+		FromStatus:    res.Status,
+		ToStatus:      status,
+		StartTime:     start,
+		EndTime:       end,
+	})
+	if err != nil {
+		switch helpers.Status(err) {
+		case http.StatusBadRequest:
+			m.App.Session.Put(r.Context(), "error", "Your booking was changed by someone else, please check it and try again")
+		case http.StatusConflict:
+			m.App.Session.Put(r.Context(), "error", slotTakenMessage)
+		default:
+			helpers.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, r, m.manageBookingLink(res.ID), http.StatusSeeOther)
+		return
+	}
+
+	res.Status = status
+	res.Date, res.StartTime, res.EndTime = start, start, end
+
+	m.audit(r, "reservation.status", fmt.Sprintf("reservation:%d", res.ID), before, res)
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+
+	//The booking has already changed, so failed emails are only logged and shown as a warning
+	msg, err := m.counselingSessionMail(res, status)
+	if err == nil {
+		err = m.queueMail(msg)
+	}
+	if err == nil {
+		err = m.notifyCounselor(res, res.CounselingSessionID, "", previousStart)
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("Error sending booking change emails for reservation", res.ID, err)
+		m.App.Session.Put(r.Context(), "warning", "We could not email you about this change, but your booking has been updated")
+	}
+
+	http.Redirect(w, r, m.manageBookingLink(res.ID), http.StatusSeeOther)
+}
+
+//Changes to a reservation's counselor that counselors are told about. An empty change is the client
+//moving or cancelling their session
+const (
+	counselorAssigned   = "assigned"
+	counselorUnassigned = "unassigned"
+)
+
+//notifyCounselor emails a counselor about a change to one of their reservations.
+//Counselors without a linked user account are not emailed
+func (m *Repository) notifyCounselor(res models.Reservation, counselingSessionID int, change string, previousStart time.Time) error {
+	counselor, err := m.DB.GetCounselorByID(counselingSessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if counselor.UserEmail == "" {
+		return nil
+	}
+
+	subject := "A client moved their counseling session"
+	switch {
+	case change == counselorAssigned:
+		subject = "You have been given a counseling session"
+	case change == counselorUnassigned:
+		subject = "Your counseling session was given to another counselor"
+	case models.IsCancelledReservation(res.Status):
+		subject = "A client cancelled their counseling session"
+	}
+
+	start, end := sessionTimes(res)
+	msg, err := m.renderMail(counselor.UserEmail, subject, "counselor-booking-changed.email.html", models.BookingChangeEmail{
+		EmailData:     models.EmailData{FirstName: counselor.CounselorName, BaseURL: m.App.BaseURL},
+		Client:        res.FirstName + " " + res.LastName,
+		Status:        res.Status,
+		Change:        change,
+		Start:         start,
+		End:           end,
+		PreviousStart: previousStart,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.queueMail(msg)
+}
+
+//Logout logs a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	m.audit(r, "auth.logout", "", nil, nil)
+
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+//ShowLogin shows the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Templates(w, r, "login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	//Renew token when logging in and out
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	log.Println("email ", email, "password: ", password)
 	if !form.Valid() {
 		//Take user back
 		render.Templates(w, r, "login.page.html", &models.TemplateData{
@@ -728,12 +1494,14 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	id, _, err := m.DB.Authenticate(email, password)
 	if err != nil {
 		log.Println("Auth Error", err)
+		m.audit(r, "auth.login_failed", email, nil, nil)
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
 	m.App.Session.Put(r.Context(), "userId", id)
+	m.audit(r, "auth.login", email, nil, nil)
 
 	log.Println("logged in")
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
@@ -760,7 +1528,14 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	res.CounselingSession.CounselorName = "Session1"
+	if res.CounselingSession.CounselorName == "" && res.CounselingSessionID != 0 {
+		c, err := m.DB.GetCounselorByID(res.CounselingSessionID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		res.CounselingSession = c
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -781,139 +1556,3240 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+//AdminDashboard shows the dashboard cards and charts. Their numbers come from AdminDashboardJSON
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Templates(w, r, "admin.dashboard.page.html", &models.TemplateData{})
 }
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
-
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
 
-	log.Print(reservations)
+//dashboardWeeks is how many weeks the dashboard charts show unless asked for more or fewer
+const dashboardWeeks = 8
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-
-	log.Print(data["reservations"])
-
-	render.Templates(w, r, "admin.new-reservations.page.html", &models.TemplateData{
-		Data: data,
-	})
+//weekStart is midnight on the Monday of t's week
+func weekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
 }
 
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+//AdminDashboardJSON returns the dashboard statistics as JSON. weeks, up to a year,
+//sets how far back the weekly charts go
+func (m *Repository) AdminDashboardJSON(w http.ResponseWriter, r *http.Request) {
+	weeks := dashboardWeeks
+	if n, err := strconv.Atoi(r.URL.Query().Get("weeks")); err == nil && n > 0 && n <= 52 {
+		weeks = n
+	}
 
+	now := time.Now()
+	stats, err := m.DB.DashboardStats(weekStart(now), weeks, now)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.ErrorLog.Println(err)
+		writeJSON(w, http.StatusInternalServerError, jsonResponse{
+			OK:      false,
+			Message: "Error connecting to Database",
+		})
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	writeJSON(w, http.StatusOK, stats)
+}
 
-	render.Templates(w, r, "admin.all-reservations.page.html", &models.TemplateData{
-		Data: data,
-	})
+//RecordContentView counts a view of a hadith, ayah, dua or surah. Failures are only logged,
+//they never stop the content being shown
+func (m *Repository) RecordContentView(contentType, contentID string) {
+	err := m.DB.RecordContentView(contentType, contentID, time.Now())
+	if err != nil {
+		m.App.ErrorLog.Println("could not record view of", contentType, contentID, err)
+	}
 }
 
-//AdminShowReservation shows the reservation details
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+//reservationsPerPage is how many reservations the admin lists show at once
+const reservationsPerPage = 25
+
+//reservationSorts are the columns the admin reservation lists can be sorted by
+var reservationSorts = []string{"date", "created", "name", "counselor", "status"}
+
+//listPage describes one page of a paginated admin list
+type listPage struct {
+	Number  int
+	Pages   int
+	Total   int
+	First   int
+	Last    int
+	PrevURL string
+	NextURL string
+}
 
-	exploded := strings.Split(r.RequestURI, "/")
+//pageURL is the current URL moved to page
+func pageURL(r *http.Request, page int) string {
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	return r.URL.Path + "?" + q.Encode()
+}
 
-	id, err := strconv.Atoi(exploded[4])
+//reservationFilterFromQuery reads the reservation list filters, sort and page from the URL
+func reservationFilterFromQuery(r *http.Request) (models.ReservationFilter, int) {
+	q := r.URL.Query()
 
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	f := models.ReservationFilter{
+		Status: q.Get("status"),
+		Search: strings.TrimSpace(q.Get("q")),
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("dir") == "desc",
+		Limit:  reservationsPerPage,
 	}
 
-	log.Print(id)
-
-	src := exploded[3]
+	f.CounselingSessionID, _ = strconv.Atoi(q.Get("counselor"))
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	if from, err := time.ParseInLocation("2006-01-02", q.Get("from"), time.Local); err == nil {
+		f.From = from
+	}
 
-	//Get reservation from the Database
-	res, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	if to, err := time.ParseInLocation("2006-01-02", q.Get("to"), time.Local); err == nil {
+		f.To = to.AddDate(0, 0, 1)
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = res
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	f.Offset = (page - 1) * reservationsPerPage
 
-	render.Templates(w, r, "admin.reservations.show.page.html", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
-	})
+	return f, page
 }
 
-//AdminPostShowReservation shows the reservation details
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
-
-	err := r.ParseForm()
+//renderReservationList shows one page of the reservations matching f, with links to
+//sort by each column and move between pages
+func (m *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, src, tmpl string, f models.ReservationFilter, page int) {
+	reservations, total, err := m.DB.FilterReservations(f)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	exploded := strings.Split(r.RequestURI, "/")
-
-	id, err := strconv.Atoi(exploded[4])
 
+	counselors, err := m.DB.AllCounselors()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	log.Print(id)
-
-	src := exploded[3]
+	q := r.URL.Query()
 
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
 
-	//Get reservation from the Database
-	res, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	export := r.URL.Query()
+	export.Del("page")
+	if f.Status != "" {
+		export.Set("status", f.Status)
 	}
-
-	res.FirstName = r.Form.Get("first-name")
-	res.LastName = r.Form.Get("last-name")
-	res.Email = r.Form.Get("email")
-	//res.Phone = r.Form.Get("phone")
-
-	err = m.DB.UpdateReservation(res)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	stringMap["export"] = "/admin/reservations/export?" + export.Encode()
+	stringMap["report"] = "/admin/reservations/report?" + export.Encode()
+	for _, key := range []string{"status", "counselor", "from", "to", "q", "sort", "dir"} {
+		stringMap[key] = q.Get(key)
 	}
 
+	sorts := make(map[string]string)
+	for _, key := range reservationSorts {
+		sq := r.URL.Query()
+		sq.Del("page")
+		sq.Set("sort", key)
+		sq.Set("dir", "asc")
+		if (f.Sort == key || (f.Sort == "" && key == "date")) && !f.Desc {
+			sq.Set("dir", "desc")
+		}
+		sorts[key] = r.URL.Path + "?" + sq.Encode()
+	}
+
+	p := listPage{
+		Number: page,
+		Pages:  (total + reservationsPerPage - 1) / reservationsPerPage,
+		Total:  total,
+	}
+	if len(reservations) > 0 {
+		p.First = f.Offset + 1
+		p.Last = f.Offset + len(reservations)
+	}
+	if page > 1 {
+		p.PrevURL = pageURL(r, page-1)
+	}
+	if page < p.Pages {
+		p.NextURL = pageURL(r, page+1)
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["counselors"] = counselors
+	data["statuses"] = models.ReservationStatuses
+	data["sorts"] = sorts
+	data["page"] = p
+
+	render.Templates(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//AdminNewReservations lists the reservations still waiting to be confirmed
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	f, page := reservationFilterFromQuery(r)
+	f.Status = models.ReservationRequested
+
+	m.renderReservationList(w, r, "new", "admin.new-reservations.page.html", f, page)
+}
+
+//AdminAllReservations lists every reservation
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	f, page := reservationFilterFromQuery(r)
+
+	m.renderReservationList(w, r, "all", "admin.all-reservations.page.html", f, page)
+}
+
+//reportTotal is one line of a reservation report's totals
+type reportTotal struct {
+	Name  string
+	Count int
+}
+
+//reservationReport sums up a list of reservations per counselor, status and week
+type reservationReport struct {
+	Total       int
+	ByCounselor []reportTotal
+	ByStatus    []reportTotal
+	ByWeek      []reportTotal
+}
+
+//newReservationReport totals reservations per counselor, per status and per week.
+//Weeks start on Monday and are named after that day
+func newReservationReport(reservations []models.Reservation) reservationReport {
+	report := reservationReport{Total: len(reservations)}
+
+	counselors := make(map[string]int)
+	statuses := make(map[string]int)
+	weeks := make(map[string]int)
+	for _, res := range reservations {
+		name := res.CounselingSession.CounselorName
+		if name == "" {
+			name = "Unassigned"
+		}
+		counselors[name]++
+
+		statuses[res.Status]++
+
+		weeks[weekStart(res.StartTime).Format("2006-01-02")]++
+	}
+
+	for name, count := range counselors {
+		report.ByCounselor = append(report.ByCounselor, reportTotal{Name: name, Count: count})
+	}
+	sort.Slice(report.ByCounselor, func(i, j int) bool {
+		return report.ByCounselor[i].Name < report.ByCounselor[j].Name
+	})
+
+	for _, status := range models.ReservationStatuses {
+		if statuses[status] > 0 {
+			report.ByStatus = append(report.ByStatus, reportTotal{Name: models.ReservationStatusLabel(status), Count: statuses[status]})
+		}
+	}
+
+	for week, count := range weeks {
+		report.ByWeek = append(report.ByWeek, reportTotal{Name: week, Count: count})
+	}
+	sort.Slice(report.ByWeek, func(i, j int) bool {
+		return report.ByWeek[i].Name < report.ByWeek[j].Name
+	})
+
+	return report
+}
+
+//reportFilterFromQuery reads the reservation list filters for an export, which covers every page
+func reportFilterFromQuery(r *http.Request) models.ReservationFilter {
+	f, _ := reservationFilterFromQuery(r)
+	f.Limit = 0
+	f.Offset = 0
+	return f
+}
+
+//AdminReservationsExport downloads the filtered reservations as CSV
+func (m *Repository) AdminReservationsExport(w http.ResponseWriter, r *http.Request) {
+	reservations, _, err := m.DB.FilterReservations(reportFilterFromQuery(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "reservation.export", "reservations", nil, r.URL.Query())
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("reservations-%s.csv", time.Now().Format("20060102"))))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "first_name", "last_name", "email", "counselor", "status", "start_time", "end_time", "booked_at"})
+
+	for _, res := range reservations {
+		_ = cw.Write(helpers.CSVRecord(
+			strconv.Itoa(res.ID),
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.CounselingSession.CounselorName,
+			res.Status,
+			res.StartTime.Format(time.RFC3339),
+			res.EndTime.Format(time.RFC3339),
+			res.CreatedAt.Format(time.RFC3339),
+		))
+	}
+
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+//AdminReservationsReport shows a printable report of the filtered reservations with totals
+//per counselor, status and week. Browsers can save it as a PDF from the print dialog
+func (m *Repository) AdminReservationsReport(w http.ResponseWriter, r *http.Request) {
+	f := reportFilterFromQuery(r)
+
+	reservations, _, err := m.DB.FilterReservations(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "reservation.report", "reservations", nil, r.URL.Query())
+
+	stringMap := make(map[string]string)
+	stringMap["generated"] = time.Now().Format("2006-01-02 15:04")
+	stringMap["from"] = "the first booking"
+	if !f.From.IsZero() {
+		stringMap["from"] = f.From.Format("2006-01-02")
+	}
+	stringMap["to"] = "the last booking"
+	if !f.To.IsZero() {
+		stringMap["to"] = f.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if f.Status != "" {
+		stringMap["status"] = models.ReservationStatusLabel(f.Status)
+	}
+	stringMap["q"] = f.Search
+
+	if f.CounselingSessionID > 0 {
+		c, err := m.DB.GetCounselorByID(f.CounselingSessionID)
+		if err == nil {
+			stringMap["counselor"] = c.CounselorName
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["report"] = newReservationReport(reservations)
+
+	render.Templates(w, r, "admin.reservations.report.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//AdminShowReservation shows the reservation details
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	log.Print(id)
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["back"] = reservationsPath(src)
+
+	//Get reservation from the Database
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.ReservationStatusChanges(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	canRead, err := m.canReadSessionNotes(r, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = res
+	data["actions"] = models.NextReservationStatuses(res.Status)
+	data["history"] = history
+	data["can-read-notes"] = canRead
+
+	//Admins can hand an open reservation to another counselor who is free at the same time
+	if len(models.NextReservationStatuses(res.Status)) > 0 {
+		suggestions, err := m.counselorSuggestions(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["suggestions"] = suggestions
+	}
+
+	if canRead {
+		notes, err := m.openSessionNotes(r, res.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["notes"] = notes
+	}
+
+	render.Templates(w, r, "admin.reservations.show.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+//reservationsPath is the admin page a reservation was opened from
+func reservationsPath(src string) string {
+	if src == "cal" {
+		return "/admin/calendar"
+	}
+	return fmt.Sprintf("/admin/%s-reservations", src)
+}
+
+//AdminPostShowReservation shows the reservation details
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	log.Print(id)
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	//Get reservation from the Database
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := res
+
+	res.FirstName = r.Form.Get("first-name")
+	res.LastName = r.Form.Get("last-name")
+	res.Email = r.Form.Get("email")
+	res.MeetingLink = r.Form.Get("meeting-link")
+	//res.Phone = r.Form.Get("phone")
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "reservation.update", fmt.Sprintf("reservation:%d", res.ID), before, res)
+
 	m.App.Session.Put(r.Context(), "flash", "changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/%s-reservations", src), http.StatusSeeOther)
+	http.Redirect(w, r, reservationsPath(src), http.StatusSeeOther)
 }
 
-//AdminReservationsCalender display the reservation calender
+//AdminReservationsCalendar shows the month and week calendar of bookings and blocked time.
+//The events themselves come from AdminReservationsJSON
 func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	render.Templates(w, r, "admin.reservations.calendar.page.html", &models.TemplateData{})
+	counselors, err := m.DB.AllCounselors()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["date"] = time.Now().Format("2006-01-02")
+	if _, err := time.Parse("2006-01-02", r.URL.Query().Get("date")); err == nil {
+		stringMap["date"] = r.URL.Query().Get("date")
+	}
+	stringMap["view"] = "month"
+	if r.URL.Query().Get("view") == "week" {
+		stringMap["view"] = "week"
+	}
+
+	data := make(map[string]interface{})
+	data["counselors"] = counselors
+
+	render.Templates(w, r, "admin.reservations.calendar.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
 }
 
-//AdminProcessReservation processes the reservation
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
+//calendarResponse is the JSON feed behind the admin reservation calendar
+type calendarResponse struct {
+	OK      bool                   `json:"ok"`
+	Message string                 `json:"message"`
+	Events  []models.CalendarEvent `json:"events"`
+}
+
+//maxCalendarRange is the longest period the calendar feed returns at once
+const maxCalendarRange = 42 * 24 * time.Hour
+
+//AdminReservationsJSON returns the bookings and owner blocks from start up to, but not including, end.
+//Both are dates; counseling-session-id limits the feed to one counselor
+func (m *Repository) AdminReservationsJSON(w http.ResponseWriter, r *http.Request) {
+	start, startErr := time.ParseInLocation("2006-01-02", r.URL.Query().Get("start"), time.Local)
+	end, endErr := time.ParseInLocation("2006-01-02", r.URL.Query().Get("end"), time.Local)
+	if startErr != nil || endErr != nil || !end.After(start) || end.Sub(start) > maxCalendarRange {
+		writeJSON(w, http.StatusBadRequest, calendarResponse{
+			OK:      false,
+			Message: "Please choose a start date before the end date, at most six weeks apart",
+			Events:  []models.CalendarEvent{},
+		})
+		return
+	}
+
+	counselingSessionID, _ := strconv.Atoi(r.URL.Query().Get("counseling-session-id"))
+
+	restrictions, err := m.DB.CounselingTimeRestrictions(start, end, counselingSessionID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		writeJSON(w, http.StatusInternalServerError, calendarResponse{
+			OK:      false,
+			Message: "Error connecting to Database",
+			Events:  []models.CalendarEvent{},
+		})
+		return
+	}
+
+	events := []models.CalendarEvent{}
+	for _, x := range restrictions {
+		event := models.CalendarEvent{
+			ID:                  x.ID,
+			Kind:                "block",
+			Title:               "Blocked",
+			CounselingSessionID: x.CounselingSessionID,
+			CounselorName:       x.Session.CounselorName,
+			StartTime:           x.StartTime,
+			EndTime:             x.EndTime,
+		}
+
+		if x.RestrictionID == models.RestrictionReservation && x.ReservationID > 0 {
+			event.ID = x.ReservationID
+			event.Kind = "reservation"
+			event.Title = strings.TrimSpace(x.Reservation.FirstName + " " + x.Reservation.LastName)
+			event.Status = x.Reservation.Status
+			event.URL = fmt.Sprintf("/admin/reservations/cal/%d", x.ReservationID)
+		}
+
+		events = append(events, event)
+	}
+
+	writeJSON(w, http.StatusOK, calendarResponse{
+		OK:      true,
+		Message: fmt.Sprintf("%d events", len(events)),
+		Events:  events,
+	})
+}
+
+//calendarPath is the calendar page showing date
+func calendarPath(date time.Time) string {
+	return "/admin/calendar?date=" + date.Format("2006-01-02")
+}
+
+//AdminPostCalendarBlock blocks out time in a counselor's calendar so it can't be booked
+func (m *Repository) AdminPostCalendarBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	counselingSessionID, _ := strconv.Atoi(r.Form.Get("counseling-session-id"))
+	date, dateErr := time.ParseInLocation("2006-01-02", r.Form.Get("date"), time.Local)
+	startMinute, startErr := parseClock(r.Form.Get("start-time"))
+	endMinute, endErr := parseClock(r.Form.Get("end-time"))
+	if counselingSessionID == 0 || dateErr != nil || startErr != nil || endErr != nil || endMinute <= startMinute {
+		m.App.Session.Put(r.Context(), "error", "Choose a counselor, a day and a start time before the end time")
+		http.Redirect(w, r, "/admin/calendar", http.StatusSeeOther)
+		return
+	}
+
+	block := models.CounselingSessionTimeRestriction{
+		StartTime:           date.Add(time.Duration(startMinute) * time.Minute),
+		EndTime:             date.Add(time.Duration(endMinute) * time.Minute),
+		Date:                date,
+		CounselingSessionID: counselingSessionID,
+		RestrictionID:       models.RestrictionOwnerBlock,
+	}
+
+	err = m.DB.InsertCounselingTimeRestriction(block)
+	if err != nil && helpers.Status(err) == http.StatusConflict {
+		m.App.Session.Put(r.Context(), "error", "That time overlaps a booking or another block")
+		http.Redirect(w, r, calendarPath(date), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "calendar.block_add", fmt.Sprintf("counselor:%d", counselingSessionID), nil, block)
+
+	m.App.Session.Put(r.Context(), "flash", "Time blocked")
+	http.Redirect(w, r, calendarPath(date), http.StatusSeeOther)
+}
+
+//AdminPostDeleteCalendarBlock frees time an admin blocked out
+func (m *Repository) AdminPostDeleteCalendarBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteOwnerBlock(id)
+	if helpers.Status(err) == http.StatusNotFound {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "calendar.block_delete", fmt.Sprintf("restriction:%d", id), map[string]int{"block": id}, nil)
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	path := "/admin/calendar"
+	if date, err := time.Parse("2006-01-02", r.FormValue("date")); err == nil {
+		path = calendarPath(date)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block removed")
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+//AdminPostReservationStatus moves a reservation to the posted status and emails the client about it.
+//Rescheduling also takes the new date and start-time, keeping the session's length
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	showPage := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+	status := r.Form.Get("status")
+
+	if !models.CanTransitionReservation(res.Status, status) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can not be marked as %s",
+			strings.ToLower(models.ReservationStatusLabel(res.Status)), strings.ToLower(models.ReservationStatusLabel(status))))
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	before := res
+	start, end := sessionTimes(res)
+
+	if status == models.ReservationRescheduled {
+		newStart, err := time.ParseInLocation("2006-01-02 15:04", r.Form.Get("date")+" "+r.Form.Get("start-time"), time.Local)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Choose the new date and start time")
+			http.Redirect(w, r, showPage, http.StatusSeeOther)
+			return
+		}
+		if !newStart.After(time.Now()) {
+			m.App.Session.Put(r.Context(), "error", pastStartMessage)
+			http.Redirect(w, r, showPage, http.StatusSeeOther)
+			return
+		}
+
+		slot, ok, err := m.offeredSlot(res.CounselingSessionID, newStart)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !ok {
+			m.App.Session.Put(r.Context(), "error", slotTakenMessage)
+			http.Redirect(w, r, showPage, http.StatusSeeOther)
+			return
+		}
+		start, end = slot.StartTime, slot.EndTime
+	}
+
+	err = m.DB.TransitionReservation(models.ReservationStatusChange{
+		ReservationID: res.ID,
+		FromStatus:    res.Status,
+		ToStatus:      status,
+		ChangedBy:     m.App.Session.GetInt(r.Context(), "userId"),
+		StartTime:     start,
+		EndTime:       end,
+	})
+	if err != nil {
+		switch helpers.Status(err) {
+		case http.StatusBadRequest:
+			m.App.Session.Put(r.Context(), "error", "This reservation was changed by someone else, please check it and try again")
+		case http.StatusConflict:
+			m.App.Session.Put(r.Context(), "error", slotTakenMessage)
+		default:
+			helpers.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	res.Status = status
+	res.Date, res.StartTime, res.EndTime = start, start, end
+
+	m.audit(r, "reservation.status", fmt.Sprintf("reservation:%d", res.ID), before, res)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as "+strings.ToLower(models.ReservationStatusLabel(status)))
+
+	//The status has already changed, so a failed email is only logged and shown as a warning
+	msg, err := m.counselingSessionMail(res, status)
+	if err == nil {
+		err = m.queueMail(msg)
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("Error sending reservation status email for reservation", res.ID, err)
+		m.App.Session.Put(r.Context(), "warning", "The client could not be emailed about this change")
+	}
+
+	http.Redirect(w, r, reservationsPath(src), http.StatusSeeOther)
+}
+
+//sessionTimes returns when a reservation starts and ends, joining its date with its start and end times
+func sessionTimes(res models.Reservation) (time.Time, time.Time) {
+	at := func(t time.Time) time.Time {
+		return time.Date(res.Date.Year(), res.Date.Month(), res.Date.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+	}
+	return at(res.StartTime), at(res.EndTime)
+}
+
+//reservationMail is the email sent to the client when their reservation moves to a status.
+//Method is the kind of calendar invite attached, if there is one
+type reservationMail struct {
+	Subject  string
+	Template string
+	Method   string
+}
+
+//reservationMails are the emails sent for each reservation status
+var reservationMails = map[string]reservationMail{
+	models.ReservationConfirmed: {"Your counseling session is confirmed",
+		"counseling-confirmed.email.html", ics.MethodRequest},
+	models.ReservationRescheduled: {"Your counseling session has moved",
+		"counseling-rescheduled.email.html", ics.MethodRequest},
+	models.ReservationCompleted: {"Thank you for attending your counseling session",
+		"counseling-completed.email.html", ""},
+	models.ReservationCancelledByUser: {"Your counseling session has been cancelled",
+		"counseling-cancelled.email.html", ics.MethodCancel},
+	models.ReservationCancelledByStaff: {"Your counseling session has been cancelled",
+		"counseling-cancelled.email.html", ics.MethodCancel},
+	models.ReservationNoShow: {"We missed you at your counseling session",
+		"counseling-missed.email.html", ""},
+}
+
+//counselorChangedMail is sent to the client with an updated invite when their session is given to another counselor
+var counselorChangedMail = reservationMail{"Your counseling session has a new counselor",
+	"counseling-counselor-changed.email.html", ics.MethodRequest}
+
+//counselingSessionMail builds the email telling the client their reservation moved to status,
+//with a calendar invite or cancellation attached when the session's time changed
+func (m *Repository) counselingSessionMail(res models.Reservation, status string) (models.MailData, error) {
+	mail, ok := reservationMails[status]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("no email for reservation status %s", status)
+	}
+
+	return m.sessionMail(res, mail)
+}
+
+//sessionMail builds mail about a reservation for the client, attaching a calendar invite when mail has a method
+func (m *Repository) sessionMail(res models.Reservation, mail reservationMail) (models.MailData, error) {
+	start, end := sessionTimes(res)
+
+	msg, err := m.renderMail(res.Email, mail.Subject, mail.Template, models.CounselingSessionEmail{
+		EmailData: models.EmailData{
+			FirstName: res.FirstName,
+			LastName:  res.LastName,
+			BaseURL:   m.App.BaseURL,
+		},
+		Counselor:   res.CounselingSession.CounselorName,
+		Start:       start,
+		End:         end,
+		MeetingLink: res.MeetingLink,
+		ManageLink:  m.manageBookingLink(res.ID),
+	})
+	if err != nil || mail.Method == "" {
+		return msg, err
+	}
+
+	attendees := []ics.Person{{Name: res.FirstName + " " + res.LastName, Email: res.Email}}
+
+	counselor, err := m.DB.GetCounselorByID(res.CounselingSessionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return msg, err
+	}
+	if counselor.UserEmail != "" {
+		attendees = append(attendees, ics.Person{Name: counselor.CounselorName, Email: counselor.UserEmail})
+	}
+
+	//Every update carries a higher sequence so calendars replace the earlier invite
+	sequence, err := m.DB.NextInviteSequence(res.ID)
+	if err != nil {
+		return msg, err
+	}
+
+	invite := ics.Invite(mail.Method, ics.Event{
+		UID:         fmt.Sprintf("reservation-%d@dailyproductivemuslim", res.ID),
+		Sequence:    sequence,
+		Start:       start,
+		End:         end,
+		Summary:     "Counseling session with " + res.CounselingSession.CounselorName,
+		Description: res.MeetingLink,
+		Location:    res.MeetingLink,
+		URL:         res.MeetingLink,
+		Organizer:   ics.Person{Name: "Daily Productive Muslim", Email: msg.From},
+		Attendees:   attendees,
+	})
+
+	msg.Attachments = []models.Attachment{{
+		Name:        "invite.ics",
+		ContentType: "text/calendar; charset=utf-8; method=" + mail.Method,
+		Data:        invite,
+	}}
+
+	return msg, nil
+}
+
+//personalDataFor collects everything stored about an email address
+func (m *Repository) personalDataFor(email string) (models.PersonalData, error) {
+	pd := models.PersonalData{
+		ExportedAt: time.Now(),
+		Email:      email,
+	}
+
+	user, err := m.DB.GetUserByEmail(email)
+	if err == nil {
+		user.Password = ""
+		pd.Profile = &user
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return pd, err
+	}
+
+	//The audit log is the only tracking tied to a person. Before and after snapshots are left out,
+	//they can hold other people's data
+	if pd.Profile != nil {
+		events, err := m.DB.AuditEvents(models.AuditFilter{ActorID: user.ID})
+		if err != nil {
+			return pd, err
+		}
+		for _, e := range events {
+			pd.Activity = append(pd.Activity, models.ActivityRecord{
+				Action:    e.Action,
+				IPAddress: e.IPAddress,
+				UserAgent: e.UserAgent,
+				CreatedAt: e.CreatedAt,
+			})
+		}
+	}
+
+	subscriber, err := m.DB.GetNewsletterSubscriberByEmail(email)
+	if err == nil {
+		pd.Newsletter = append(pd.Newsletter, subscriber)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return pd, err
+	}
+
+	reservations, err := m.DB.GetReservationsByEmail(email)
+	if err != nil {
+		return pd, err
+	}
+	pd.Reservations = reservations
+
+	return pd, nil
+}
+
+//writePersonalDataExport sends personal data as a JSON file or as a ZIP holding the JSON file
+func writePersonalDataExport(w http.ResponseWriter, pd models.PersonalData, format string) error {
+	out, err := json.MarshalIndent(pd, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("personal-data-%s", pd.ExportedAt.Format("20060102"))
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		_, err = w.Write(out)
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	f, err := zw.Create("personal-data.json")
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(out); err != nil {
+		return err
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+//Account shows the logged in user's account and privacy options
+func (m *Repository) Account(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "userId"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	counselor, err := m.DB.GetCounselorByUserID(user.ID)
+	if err == nil {
+		data["counselor"] = counselor
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Templates(w, r, "account.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+//AccountExport downloads everything stored about the logged in user
+func (m *Repository) AccountExport(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "userId"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	pd, err := m.personalDataFor(user.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "account.export", fmt.Sprintf("user:%d", user.ID), nil, nil)
+
+	err = writePersonalDataExport(w, pd, r.URL.Query().Get("format"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+}
+
+//PostAccountDelete deletes the logged in user's account and anonymizes their reservations
+func (m *Repository) PostAccountDelete(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "userId"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirm-email")
+
+	if !strings.EqualFold(strings.TrimSpace(form.Get("confirm-email")), user.Email) {
+		form.Errors.Add("confirm-email", "Please type your account email to confirm")
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["user"] = user
+		render.Templates(w, r, "account.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	err = m.DB.AnonymizePersonalData(user.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "account.delete", fmt.Sprintf("user:%d", user.ID), nil, nil)
+
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Your account has been deleted")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+//AdminPrivacy shows the admin tool for handling data export and deletion requests
+func (m *Repository) AdminPrivacy(w http.ResponseWriter, r *http.Request) {
+	render.Templates(w, r, "admin.privacy.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//AdminPostPrivacyExport downloads everything stored about an email address
+func (m *Repository) AdminPostPrivacyExport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Templates(w, r, "admin.privacy.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	pd, err := m.personalDataFor(form.Get("email"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "privacy.export", form.Get("email"), nil, nil)
+
+	err = writePersonalDataExport(w, pd, form.Get("format"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+}
+
+//AdminPostPrivacyDelete deletes the account for an email address and anonymizes its reservations
+func (m *Repository) AdminPostPrivacyDelete(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Templates(w, r, "admin.privacy.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.AnonymizePersonalData(form.Get("email"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "privacy.delete", emailDigest(form.Get("email")), nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Personal data for %s deleted", form.Get("email")))
+	http.Redirect(w, r, "/admin/privacy", http.StatusSeeOther)
+}
+
+//emailDigest stands in for an email address in the audit log once its data is deleted. The same
+//address always gives the same digest, so a deletion can still be looked up without storing the address
+func emailDigest(email string) string {
+	return "email:" + helpers.Sign(strings.ToLower(strings.TrimSpace(email)))
+}
+
+//audit records an action in the audit log. A failure to write is logged but does not stop the request
+func (m *Repository) audit(r *http.Request, action, target string, before, after interface{}) {
+	e := models.AuditEvent{
+		ActorID:   m.App.Session.GetInt(r.Context(), "userId"),
+		Action:    action,
+		Target:    target,
+		IPAddress: helpers.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	e.Before, e.After = auditDiff(before, after)
+
+	err := m.DB.InsertAuditEvent(e)
+	if err != nil {
+		m.App.ErrorLog.Println("could not write audit event", action, err)
+	}
+}
+
+//confidentialAuditFields are never written to the audit log, even when they change
+var confidentialAuditFields = map[string]bool{"answers": true, "body": true, "ciphertext": true, "password": true}
+
+//auditDiff returns the fields that differ between before and after as JSON, without confidential
+//fields. Values that are not JSON objects are kept whole
+func auditDiff(before, after interface{}) (string, string) {
+	b, bok := auditFields(before)
+	a, aok := auditFields(after)
+	if !bok || !aok {
+		return auditJSON(before), auditJSON(after)
+	}
+
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changedBefore[k] = v
+		}
+	}
+	for k, v := range a {
+		if !reflect.DeepEqual(v, b[k]) {
+			changedAfter[k] = v
+		}
+	}
+
+	var beforeJSON, afterJSON string
+	if len(changedBefore) > 0 {
+		beforeJSON = auditJSON(changedBefore)
+	}
+	if len(changedAfter) > 0 {
+		afterJSON = auditJSON(changedAfter)
+	}
+	return beforeJSON, afterJSON
+}
+
+//auditFields returns v's JSON fields without the confidential ones. It reports false when v
+//is not a JSON object
+func auditFields(v interface{}) (map[string]interface{}, bool) {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields, true
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(out, &fields) != nil {
+		return nil, false
+	}
+
+	for k := range fields {
+		if confidentialAuditFields[strings.ToLower(k)] {
+			delete(fields, k)
+		}
+	}
+	return fields, true
+}
+
+//auditJSON returns v as JSON, or "" when there is nothing to record
+func auditJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+//auditFilterFromQuery reads the audit log filters from the query string
+func auditFilterFromQuery(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+
+	f := models.AuditFilter{
+		Action: q.Get("action"),
+		Actor:  q.Get("actor"),
+	}
+
+	if from, err := time.Parse("2006-01-02", q.Get("from")); err == nil {
+		f.From = from
+	}
+
+	if to, err := time.Parse("2006-01-02", q.Get("to")); err == nil {
+		f.To = to.AddDate(0, 0, 1)
+	}
+
+	return f
+}
+
+//AdminAudit shows the audit log
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	f := auditFilterFromQuery(r)
+	f.Limit = 500
+
+	events, err := m.DB.AuditEvents(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["action"] = r.URL.Query().Get("action")
+	stringMap["actor"] = r.URL.Query().Get("actor")
+	stringMap["from"] = r.URL.Query().Get("from")
+	stringMap["to"] = r.URL.Query().Get("to")
+
+	data := make(map[string]interface{})
+	data["events"] = events
+
+	render.Templates(w, r, "admin.audit.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//AdminAuditExport downloads the filtered audit log as CSV
+func (m *Repository) AdminAuditExport(w http.ResponseWriter, r *http.Request) {
+	events, err := m.DB.AuditEvents(auditFilterFromQuery(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102"))))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "time", "actor_id", "actor", "action", "target", "before", "after", "ip_address", "user_agent"})
+
+	for _, e := range events {
+		_ = cw.Write(helpers.CSVRecord(
+			strconv.Itoa(e.ID),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(e.ActorID),
+			e.ActorEmail,
+			e.Action,
+			e.Target,
+			e.Before,
+			e.After,
+			e.IPAddress,
+			e.UserAgent,
+		))
+	}
+
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+//campaignFromForm validates the campaign form and copies its values into c
+func campaignFromForm(form *forms.Form, c *models.Campaign) {
+	form.Required("subject", "body")
+
+	c.Subject = form.Get("subject")
+	c.Body = form.Get("body")
+	c.Audience = form.Get("audience")
+	if c.Audience == "" {
+		c.Audience = "all"
+	}
+
+	if c.Audience != "all" {
+		if _, err := strconv.Atoi(strings.TrimPrefix(c.Audience, "tag:")); err != nil || !strings.HasPrefix(c.Audience, "tag:") {
+			form.Errors.Add("audience", "Please choose who should receive the campaign")
+		}
+	}
+
+	if form.Get("action") != "schedule" {
+		c.Status = models.CampaignDraft
+		c.ScheduledAt = time.Time{}
+		return
+	}
+
+	c.Status = models.CampaignScheduled
+
+	scheduledAt, err := time.ParseInLocation("2006-01-02T15:04", form.Get("scheduled-at"), time.Local)
+	if err != nil {
+		form.Errors.Add("scheduled-at", "Please choose when the campaign should be sent")
+		return
+	}
+	c.ScheduledAt = scheduledAt
+}
+
+//renderCampaignPage shows the campaign compose page
+func (m *Repository) renderCampaignPage(w http.ResponseWriter, r *http.Request, c models.Campaign, form *forms.Form) {
+	var err error
+	counts := make(map[string]int)
+	if c.ID > 0 {
+		counts, err = m.DB.CampaignRecipientCounts(c.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	stringMap := make(map[string]string)
+	if !c.ScheduledAt.IsZero() {
+		stringMap["scheduled-at"] = c.ScheduledAt.Format("2006-01-02T15:04")
+	}
+	if c.Status == "" || c.Status == models.CampaignDraft || c.Status == models.CampaignScheduled {
+		stringMap["editable"] = "true"
+	}
+
+	tags, err := m.DB.SubscriberTags()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["campaign"] = c
+	data["tags"] = tags
+
+	render.Templates(w, r, "admin.campaign.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    counts,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+//AdminCampaigns lists the newsletter campaigns
+func (m *Repository) AdminCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := m.DB.AllCampaigns()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["campaigns"] = campaigns
+
+	render.Templates(w, r, "admin.campaigns.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminNewCampaign shows an empty campaign compose page
+func (m *Repository) AdminNewCampaign(w http.ResponseWriter, r *http.Request) {
+	m.renderCampaignPage(w, r, models.Campaign{Audience: "all"}, forms.New(nil))
+}
+
+//AdminPostNewCampaign saves a new campaign as a draft or schedules it
+func (m *Repository) AdminPostNewCampaign(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var c models.Campaign
+	form := forms.New(r.PostForm)
+	campaignFromForm(form, &c)
+
+	if !form.Valid() {
+		m.renderCampaignPage(w, r, c, form)
+		return
+	}
+
+	c.CreatedBy = m.App.Session.GetInt(r.Context(), "userId")
+
+	c.ID, err = m.DB.InsertCampaign(c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "campaign.create", fmt.Sprintf("campaign:%d", c.ID), nil, map[string]string{"status": c.Status})
+
+	m.App.Session.Put(r.Context(), "flash", "Campaign saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/campaigns/%d", c.ID), http.StatusSeeOther)
+}
+
+//AdminShowCampaign shows a campaign and its sending progress
+func (m *Repository) AdminShowCampaign(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	c, err := m.DB.GetCampaignByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderCampaignPage(w, r, c, forms.New(nil))
+}
+
+//AdminPostShowCampaign updates a draft or scheduled campaign
+func (m *Repository) AdminPostShowCampaign(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	c, err := m.DB.GetCampaignByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if c.Status != models.CampaignDraft && c.Status != models.CampaignScheduled {
+		m.App.Session.Put(r.Context(), "error", "This campaign has already been sent and can not be changed")
+		http.Redirect(w, r, fmt.Sprintf("/admin/campaigns/%d", c.ID), http.StatusSeeOther)
+		return
+	}
+
+	before := c.Status
+	form := forms.New(r.PostForm)
+	campaignFromForm(form, &c)
+
+	if !form.Valid() {
+		m.renderCampaignPage(w, r, c, form)
+		return
+	}
+
+	err = m.DB.UpdateCampaign(c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "campaign.update", fmt.Sprintf("campaign:%d", c.ID),
+		map[string]string{"status": before}, map[string]string{"status": c.Status})
+
+	m.App.Session.Put(r.Context(), "flash", "Campaign saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/campaigns/%d", c.ID), http.StatusSeeOther)
+}
+
+//AdminCancelCampaign stops a scheduled or sending campaign
+func (m *Repository) AdminCancelCampaign(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	c, err := m.DB.GetCampaignByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if c.Status == models.CampaignSent || c.Status == models.CampaignCancelled {
+		m.App.Session.Put(r.Context(), "error", "This campaign can no longer be cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/admin/campaigns/%d", c.ID), http.StatusSeeOther)
+		return
+	}
+
+	before := c.Status
+	c.Status = models.CampaignCancelled
+
+	err = m.DB.UpdateCampaign(c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "campaign.cancel", fmt.Sprintf("campaign:%d", c.ID),
+		map[string]string{"status": before}, map[string]string{"status": c.Status})
+
+	m.App.Session.Put(r.Context(), "flash", "Campaign cancelled")
+	http.Redirect(w, r, fmt.Sprintf("/admin/campaigns/%d", c.ID), http.StatusSeeOther)
+}
+
+//AdminPreviewCampaign shows the campaign body inside the email template
+func (m *Repository) AdminPreviewCampaign(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	c, err := m.DB.GetCampaignByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg, err := m.campaignMail(models.NewsletterSubscriber{Email: "preview@example.com"}, c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(msg.Content))
+}
+
+//campaignMail builds the email sending a campaign to one subscriber
+func (m *Repository) campaignMail(subscriber models.NewsletterSubscriber, c models.Campaign) (models.MailData, error) {
+	return m.newsletterMail(subscriber, c.Subject, "campaign.email.html", models.CampaignEmail{
+		EmailData: m.newsletterEmailData(subscriber),
+		Body:      template.HTML(c.Body),
+	})
+}
+
+//SendDueCampaigns sends every campaign that is due, at most rate emails per minute.
+//Campaigns interrupted by a restart carry on with the recipients not yet sent
+func (m *Repository) SendDueCampaigns(rate int) error {
+	campaigns, err := m.DB.DueCampaigns(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, c := range campaigns {
+		err = m.sendCampaign(c, rate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//SendDueReminders queues the reminder emails due offsets before confirmed counseling sessions.
+//Reminders already queued, including before a restart, are not sent again
+func (m *Repository) SendDueReminders(offsets []time.Duration) error {
+	reminders, err := m.DB.DueReminders(offsets, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, rem := range reminders {
+		msg, err := m.reminderMail(rem)
+		if err != nil {
+			m.App.ErrorLog.Println("Error building reminder for reservation", rem.Reservation.ID, err)
+			continue
+		}
+
+		queued, err := m.DB.QueueReminder(rem, msg)
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			m.App.InfoLog.Println("Queued", durationText(rem.Offset), "reminder for reservation", rem.Reservation.ID)
+		}
+	}
+
+	return nil
+}
+
+//reminderMail builds a session reminder, showing the session's time in the client's timezone
+func (m *Repository) reminderMail(rem models.Reminder) (models.MailData, error) {
+	res := rem.Reservation
+	start, end := sessionTimes(res)
+
+	loc, err := time.LoadLocation(res.Timezone)
+	if res.Timezone == "" || err != nil {
+		loc = time.Local
+	}
+
+	return m.renderMail(res.Email, "Reminder: your counseling session starts in "+durationText(rem.Offset),
+		"counseling-reminder.email.html", models.SessionReminderEmail{
+			CounselingSessionEmail: models.CounselingSessionEmail{
+				EmailData: models.EmailData{
+					FirstName: res.FirstName,
+					LastName:  res.LastName,
+					BaseURL:   m.App.BaseURL,
+				},
+				Counselor:   res.CounselingSession.CounselorName,
+				Start:       start.In(loc),
+				End:         end.In(loc),
+				MeetingLink: res.MeetingLink,
+				ManageLink:  m.manageBookingLink(res.ID),
+			},
+			StartsIn: durationText(rem.Offset),
+			Timezone: loc.String(),
+		})
+}
+
+//durationText describes a whole number of hours or minutes, like "24 hours" or "30 minutes"
+func durationText(d time.Duration) string {
+	n, unit := int(d.Minutes()), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d.Hours()), "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+//sendCampaign sends one campaign to its pending recipients
+func (m *Repository) sendCampaign(c models.Campaign, rate int) error {
+	if c.Status == models.CampaignScheduled {
+		err := m.DB.StartCampaign(c.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	interval := time.Minute / time.Duration(rate)
+
+	for {
+		//Stop if the campaign was cancelled while sending
+		current, err := m.DB.GetCampaignByID(c.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status == models.CampaignCancelled {
+			return nil
+		}
+
+		recipients, err := m.DB.PendingCampaignRecipients(c.ID, 100)
+		if err != nil {
+			return err
+		}
+
+		if len(recipients) == 0 {
+			break
+		}
+
+		for _, recipient := range recipients {
+			if recipient.Subscriber.Status != models.SubscriberConfirmed {
+				err = m.DB.UpdateCampaignRecipientStatus(recipient.ID, models.RecipientSkipped, "subscriber is "+recipient.Subscriber.Status)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			//The outbox workers send the email, so the recipient is only marked as queued here
+			msg, err := m.campaignMail(recipient.Subscriber, c)
+			if err == nil {
+				err = m.DB.QueueCampaignEmail(recipient.ID, msg)
+			}
+			if err != nil {
+				m.App.ErrorLog.Println("Error queueing campaign email to", recipient.Subscriber.Email, err)
+				err = m.DB.UpdateCampaignRecipientStatus(recipient.ID, models.RecipientFailed, err.Error())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			time.Sleep(interval)
+		}
+	}
+
+	m.App.InfoLog.Println("Campaign sent:", c.ID, c.Subject)
+
+	return m.DB.FinishCampaign(c.ID)
+}
+
+//subscriberImport is the result of reading a subscriber CSV file
+type subscriberImport struct {
+	Subscribers []models.NewsletterSubscriber
+	Invalid     []string
+	Duplicates  int
+}
+
+//parseSubscriberCSV reads subscribers from CSV. Columns are found from a header row naming
+//email, first_name and last_name; without a header the columns are email, first name, last name.
+//Rows with invalid addresses and repeated addresses are left out
+func parseSubscriberCSV(r io.Reader) (subscriberImport, error) {
+	var result subscriberImport
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return result, err
+	}
+
+	columns := map[string]int{"email": 0, "first_name": 1, "last_name": 2}
+	start := 0
+
+	if len(records) > 0 {
+		header := make(map[string]int)
+		for i, name := range records[0] {
+			name = strings.ToLower(strings.TrimSpace(name))
+			name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+			header[name] = i
+		}
+
+		if i, ok := header["email"]; ok {
+			start = 1
+			columns = map[string]int{"email": i, "first_name": -1, "last_name": -1}
+			for _, name := range []string{"first_name", "firstname"} {
+				if i, ok := header[name]; ok {
+					columns["first_name"] = i
+				}
+			}
+			for _, name := range []string{"last_name", "lastname"} {
+				if i, ok := header[name]; ok {
+					columns["last_name"] = i
+				}
+			}
+		}
+	}
+
+	field := func(record []string, column string) string {
+		i := columns[column]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	seen := make(map[string]bool)
+
+	for n, record := range records[start:] {
+		email := strings.ToLower(field(record, "email"))
+		if email == "" && len(strings.Join(record, "")) == 0 {
+			continue
+		}
+
+		if !govalidator.IsEmail(email) {
+			result.Invalid = append(result.Invalid, fmt.Sprintf("line %d: %q", n+start+1, email))
+			continue
+		}
+
+		if seen[email] {
+			result.Duplicates++
+			continue
+		}
+		seen[email] = true
+
+		result.Subscribers = append(result.Subscribers, models.NewsletterSubscriber{
+			FirstName: field(record, "first_name"),
+			LastName:  field(record, "last_name"),
+			Email:     email,
+			Status:    models.SubscriberConfirmed,
+		})
+	}
+
+	return result, nil
+}
+
+//subscriberFilterFromQuery reads the subscriber list filters from the query string
+func subscriberFilterFromQuery(r *http.Request) models.SubscriberFilter {
+	q := r.URL.Query()
+
+	f := models.SubscriberFilter{
+		Status: q.Get("status"),
+	}
+
+	f.TagID, _ = strconv.Atoi(q.Get("tag"))
+
+	if from, err := time.Parse("2006-01-02", q.Get("from")); err == nil {
+		f.From = from
+	}
+
+	if to, err := time.Parse("2006-01-02", q.Get("to")); err == nil {
+		f.To = to.AddDate(0, 0, 1)
+	}
+
+	return f
+}
+
+//AdminSubscribers lists newsletter subscribers with their tags
+func (m *Repository) AdminSubscribers(w http.ResponseWriter, r *http.Request) {
+	f := subscriberFilterFromQuery(r)
+	f.Limit = 1000
+
+	subscribers, err := m.DB.FilterNewsletterSubscribers(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tags, err := m.DB.SubscriberTags()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = r.URL.Query().Get("status")
+	stringMap["tag"] = r.URL.Query().Get("tag")
+	stringMap["from"] = r.URL.Query().Get("from")
+	stringMap["to"] = r.URL.Query().Get("to")
+
+	data := make(map[string]interface{})
+	data["subscribers"] = subscribers
+	data["tags"] = tags
+
+	render.Templates(w, r, "admin.subscribers.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+//AdminSubscribersExport downloads the filtered newsletter subscribers as CSV
+func (m *Repository) AdminSubscribersExport(w http.ResponseWriter, r *http.Request) {
+	subscribers, err := m.DB.FilterNewsletterSubscribers(subscriberFilterFromQuery(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("subscribers-%s.csv", time.Now().Format("20060102"))))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"email", "first_name", "last_name", "status", "tags", "signed_up_at", "confirmed_at", "unsubscribed_at"})
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	for _, s := range subscribers {
+		_ = cw.Write(helpers.CSVRecord(
+			s.Email,
+			s.FirstName,
+			s.LastName,
+			s.Status,
+			strings.Join(s.Tags, ";"),
+			formatTime(s.CreatedAt),
+			formatTime(s.ConfirmedAt),
+			formatTime(s.UnsubscribedAt),
+		))
+	}
+
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+//AdminPostSubscribersImport imports newsletter subscribers from an uploaded CSV file
+func (m *Repository) AdminPostSubscribersImport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Please choose a CSV file of at most 10MB")
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Please choose a CSV file to import")
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	}
+
+	defer file.Close()
+
+	result, err := parseSubscriberCSV(file)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Could not read the CSV file: %s", err))
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	}
+
+	for i := range result.Subscribers {
+		result.Subscribers[i].Token, err = helpers.RandomToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	tagID, _ := strconv.Atoi(r.FormValue("tag"))
+
+	imported, err := m.DB.ImportNewsletterSubscribers(result.Subscribers, tagID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "subscribers.import", fmt.Sprintf("tag:%d", tagID), nil, map[string]int{
+		"imported":   imported,
+		"existing":   len(result.Subscribers) - imported,
+		"duplicates": result.Duplicates,
+		"invalid":    len(result.Invalid),
+	})
+
+	msg := fmt.Sprintf("Imported %d new subscribers. %d were already subscribed, %d duplicate rows and %d invalid rows were skipped.",
+		imported, len(result.Subscribers)-imported, result.Duplicates, len(result.Invalid))
+
+	if len(result.Invalid) > 0 {
+		invalid := result.Invalid
+		if len(invalid) > 10 {
+			invalid = invalid[:10]
+		}
+		m.App.Session.Put(r.Context(), "warning", msg+" Invalid: "+strings.Join(invalid, ", "))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", msg)
+	}
+
+	http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+}
+
+//AdminPostSubscriberTags creates a subscriber tag
+func (m *Repository) AdminPostSubscriberTags(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" {
+		m.App.Session.Put(r.Context(), "error", "Please give the tag a name")
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	}
+
+	id, err := m.DB.InsertSubscriberTag(name)
+	if helpers.Status(err) == http.StatusConflict {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The tag %q already exists", name))
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "subscribers.tag_create", fmt.Sprintf("tag:%d", id), nil, map[string]string{"name": name})
+
+	m.App.Session.Put(r.Context(), "flash", "Tag created")
+	http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+}
+
+//AdminPostSubscribersTag adds a tag to, or removes a tag from, the selected subscribers
+func (m *Repository) AdminPostSubscribersTag(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tagID, err := strconv.Atoi(r.Form.Get("tag"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Please choose a tag")
+		http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+		return
+	}
+
+	var ids []int
+	for _, v := range r.Form["subscriber"] {
+		id, err := strconv.Atoi(v)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	action := "subscribers.tag_add"
+	if r.Form.Get("action") == "remove" {
+		action = "subscribers.tag_remove"
+		err = m.DB.UntagNewsletterSubscribers(tagID, ids)
+	} else {
+		err = m.DB.TagNewsletterSubscribers(tagID, ids)
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, action, fmt.Sprintf("tag:%d", tagID), nil, ids)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Updated %d subscribers", len(ids)))
+	http.Redirect(w, r, "/admin/subscribers", http.StatusSeeOther)
+}
+
+//AdminOutbox shows the queued, sent and failed emails in the outbox
+func (m *Repository) AdminOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	emails, err := m.DB.FilterOutboxEmails(status, 500)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	counts, err := m.DB.OutboxCounts()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	data := make(map[string]interface{})
+	data["emails"] = emails
+
+	render.Templates(w, r, "admin.outbox.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    counts,
+		Data:      data,
+	})
+}
+
+//AdminRetryOutboxEmail queues a failed or dead email to be sent again
+func (m *Repository) AdminRetryOutboxEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.RetryOutboxEmail(id)
+	if helpers.Status(err) == http.StatusNotFound {
+		m.App.Session.Put(r.Context(), "error", "This email can not be retried")
+		http.Redirect(w, r, "/admin/outbox", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "outbox.retry", fmt.Sprintf("outbox:%d", id), nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued to be sent again")
+	http.Redirect(w, r, "/admin/outbox", http.StatusSeeOther)
+}
+
+//emailTemplateSample is an email template used by the handlers, with sample data to preview it
+type emailTemplateSample struct {
+	Name        string
+	Description string
+	Subject     string
+	Data        interface{}
+}
+
+//emailTemplateSamples lists every email template the handlers send
+func (m *Repository) emailTemplateSamples() []emailTemplateSample {
+	subscriber := m.newsletterEmailData(models.NewsletterSubscriber{ID: 1, FirstName: "Maryam", LastName: "Yusuf"})
+	user := models.EmailData{FirstName: "Maryam", LastName: "Yusuf", BaseURL: m.App.BaseURL}
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	booking := models.CounselingSessionEmail{
+		EmailData:   user,
+		Counselor:   "Sister Aisha",
+		Start:       start,
+		End:         start.Add(time.Hour),
+		MeetingLink: "https://meet.example.com/sample",
+		ManageLink:  m.manageBookingLink(1),
+	}
+
+	return []emailTemplateSample{
+		{
+			Name:        "newsletter-confirm.email.html",
+			Description: "Sent after the newsletter signup form, asks the subscriber to confirm their address",
+			Subject:     "Please Confirm your Newsletter Signup",
+			Data: models.NewsletterConfirmEmail{
+				EmailData:   subscriber,
+				ConfirmLink: m.App.BaseURL + "/newsletter/confirm?token=sample",
+			},
+		},
+		{
+			Name:        "account-created.email.html",
+			Description: "Sent when someone registers an account",
+			Subject:     "Newsletter Signup Confirmation",
+			Data:        user,
+		},
+		{
+			Name:        "counseling-request.email.html",
+			Description: "Sent when someone books a counseling session",
+			Subject:     "We received your counseling request",
+			Data:        booking,
+		},
+		{
+			Name:        "counseling-confirmed.email.html",
+			Description: "Sent with a calendar invite when a counseling reservation is confirmed",
+			Subject:     "Your counseling session is confirmed",
+			Data:        booking,
+		},
+		{
+			Name:        "counseling-rescheduled.email.html",
+			Description: "Sent with an updated calendar invite when a counseling reservation is rescheduled",
+			Subject:     "Your counseling session has moved",
+			Data:        booking,
+		},
+		{
+			Name:        "counselor-booking-changed.email.html",
+			Description: "Sent to the counselor when a client cancels or moves their session, or it is given to another counselor",
+			Subject:     "A client moved their counseling session",
+			Data: models.BookingChangeEmail{
+				EmailData:     models.EmailData{FirstName: "Sister Aisha", BaseURL: m.App.BaseURL},
+				Client:        "Maryam Yusuf",
+				Status:        models.ReservationRescheduled,
+				Start:         start.Add(24 * time.Hour),
+				End:           start.Add(25 * time.Hour),
+				PreviousStart: start,
+			},
+		},
+		{
+			Name:        "counseling-counselor-changed.email.html",
+			Description: "Sent with an updated calendar invite when a reservation is given to another counselor",
+			Subject:     counselorChangedMail.Subject,
+			Data:        booking,
+		},
+		{
+			Name:        "counseling-reminder.email.html",
+			Description: "Sent before a confirmed counseling session, showing the time in the client's timezone",
+			Subject:     "Reminder: your counseling session starts in 24 hours",
+			Data: models.SessionReminderEmail{
+				CounselingSessionEmail: booking,
+				StartsIn:               "24 hours",
+				Timezone:               time.Local.String(),
+			},
+		},
+		{
+			Name:        "counseling-completed.email.html",
+			Description: "Sent when a counseling session is marked as completed",
+			Subject:     "Thank you for attending your counseling session",
+			Data:        booking,
+		},
+		{
+			Name:        "counseling-missed.email.html",
+			Description: "Sent when the client did not come to their counseling session",
+			Subject:     "We missed you at your counseling session",
+			Data:        booking,
+		},
+		{
+			Name:        "counseling-cancelled.email.html",
+			Description: "Sent with a calendar cancellation when a counseling reservation is cancelled",
+			Subject:     "Your counseling session has been cancelled",
+			Data:        booking,
+		},
+		{
+			Name:        "campaign.email.html",
+			Description: "Wraps the body of every newsletter campaign",
+			Subject:     "Sample newsletter campaign",
+			Data: models.CampaignEmail{
+				EmailData: subscriber,
+				Body:      template.HTML("<h2>Assalamu alaikum</h2><p>This is where the campaign body goes.</p>"),
+			},
+		},
+	}
+}
+
+//emailTemplateSampleFromURL returns the sample for the template named in the URL
+func (m *Repository) emailTemplateSampleFromURL(r *http.Request) (emailTemplateSample, bool) {
+	name := chi.URLParam(r, "name")
+	for _, sample := range m.emailTemplateSamples() {
+		if sample.Name == name {
+			return sample, true
+		}
+	}
+	return emailTemplateSample{}, false
+}
+
+//AdminEmailTemplates lists the email templates
+func (m *Repository) AdminEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["templates"] = m.emailTemplateSamples()
+
+	render.Templates(w, r, "admin.email-templates.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminPreviewEmailTemplate shows an email template rendered with sample data, as HTML or with ?format=text as plain text
+func (m *Repository) AdminPreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	sample, ok := m.emailTemplateSampleFromURL(r)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	msg, err := m.renderMail("preview@example.com", sample.Subject, sample.Name, sample.Data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(msg.TextContent))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(msg.Content))
+}
+
+//AdminPostSendTestEmail sends an email template with sample data to the logged in admin through the configured mailer
+func (m *Repository) AdminPostSendTestEmail(w http.ResponseWriter, r *http.Request) {
+	sample, ok := m.emailTemplateSampleFromURL(r)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "userId"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg, err := m.renderMail(user.Email, "[Test] "+sample.Subject, sample.Name, sample.Data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	//Send straight away rather than through the outbox so mail server problems show up here
+	err = m.App.Mailer.Send(msg)
+	if err != nil {
+		m.App.ErrorLog.Println("Error sending test email", err)
+		m.App.Session.Put(r.Context(), "error", "Could not send the test email: "+err.Error())
+		http.Redirect(w, r, "/admin/email-templates", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "email_template.test_send", sample.Name, nil, map[string]string{"to": user.Email})
+
+	m.App.Session.Put(r.Context(), "flash", "Test email sent to "+user.Email)
+	http.Redirect(w, r, "/admin/email-templates", http.StatusSeeOther)
+}
+
+//scheduleDay is one weekday row of the weekly schedule form
+type scheduleDay struct {
+	Weekday int
+	Name    string
+	Start   string
+	End     string
+}
+
+//weeklySchedule returns the weekly schedule form rows, Monday first, filled in from a counselor's windows
+func weeklySchedule(windows []models.AvailabilityWindow) []scheduleDay {
+	var days []scheduleDay
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		day := scheduleDay{Weekday: int(weekday), Name: weekday.String()}
+		for _, w := range windows {
+			if w.Weekday == weekday {
+				day.Start = render.Clock(w.StartMinute)
+				day.End = render.Clock(w.EndMinute)
+				break
+			}
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+//parseClock reads a HH:MM time as minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//splitList reads a comma separated form field
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+//counselorPath is where a counselor's page is, for admins or for the counselor themselves
+func counselorPath(c models.CounselingSession, admin bool) string {
+	if admin {
+		return fmt.Sprintf("/admin/counselors/%d", c.ID)
+	}
+	return "/account/schedule"
+}
+
+//renderCounselorPage shows a counselor's profile, weekly schedule and schedule exceptions
+func (m *Repository) renderCounselorPage(w http.ResponseWriter, r *http.Request, c models.CounselingSession,
+	windows []models.AvailabilityWindow, form *forms.Form, admin bool) {
+	var exceptions []models.ScheduleException
+
+	if c.ID > 0 {
+		var err error
+		if windows == nil {
+			windows, err = m.DB.CounselorAvailability(c.ID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+
+		exceptions, err = m.DB.CounselorScheduleExceptions(c.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["action"] = counselorPath(c, admin)
+	if c.ID == 0 {
+		stringMap["action"] = "/admin/counselors/new"
+	}
+
+	tmpl := "counselor-schedule.page.html"
+	if admin {
+		stringMap["admin"] = "1"
+		tmpl = "admin.counselor.page.html"
+	}
+
+	data := make(map[string]interface{})
+	data["counselor"] = c
+	data["days"] = weeklySchedule(windows)
+	data["exceptions"] = exceptions
+
+	render.Templates(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+//counselorFromForm reads a counselor's profile and weekly schedule from the posted form.
+//Only admins can link a user account or stop a counselor taking sessions
+func (m *Repository) counselorFromForm(r *http.Request, c models.CounselingSession, admin bool) (models.CounselingSession, []models.AvailabilityWindow, *forms.Form, error) {
+	form := forms.New(r.PostForm)
+	form.Required("counselor-name")
+
+	c.CounselorName = r.Form.Get("counselor-name")
+	c.Bio = r.Form.Get("bio")
+	c.Gender = r.Form.Get("gender")
+	c.Languages = splitList(r.Form.Get("languages"))
+	c.Specialties = splitList(r.Form.Get("specialties"))
+
+	if c.Gender != "" && c.Gender != "female" && c.Gender != "male" {
+		form.Errors.Add("gender", "Choose a gender from the list")
+	}
+
+	if admin {
+		c.Active = r.Form.Get("active") == "1"
+		c.UserEmail = strings.TrimSpace(r.Form.Get("user-email"))
+		c.UserID = 0
+
+		if c.UserEmail != "" {
+			user, err := m.DB.GetUserByEmail(c.UserEmail)
+			if errors.Is(err, sql.ErrNoRows) {
+				form.Errors.Add("user-email", "No user account has this email address")
+			} else if err != nil {
+				return c, nil, form, err
+			} else {
+				c.UserID = user.ID
+			}
+		}
+	}
+
+	var windows []models.AvailabilityWindow
+	for day := 0; day < 7; day++ {
+		start := r.Form.Get(fmt.Sprintf("start-%d", day))
+		end := r.Form.Get(fmt.Sprintf("end-%d", day))
+		if start == "" && end == "" {
+			continue
+		}
+
+		startMinute, startErr := parseClock(start)
+		endMinute, endErr := parseClock(end)
+		if startErr != nil || endErr != nil {
+			form.Errors.Add(fmt.Sprintf("day-%d", day), "Enter both times as HH:MM")
+			continue
+		}
+		if endMinute <= startMinute {
+			form.Errors.Add(fmt.Sprintf("day-%d", day), "The end time must be after the start time")
+			continue
+		}
+
+		windows = append(windows, models.AvailabilityWindow{
+			CounselingSessionID: c.ID,
+			Weekday:             time.Weekday(day),
+			StartMinute:         startMinute,
+			EndMinute:           endMinute,
+		})
+	}
+
+	return c, windows, form, nil
+}
+
+//postCounselor saves a counselor's profile and weekly schedule
+func (m *Repository) postCounselor(w http.ResponseWriter, r *http.Request, c models.CounselingSession, admin bool) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := c
+
+	c, windows, form, err := m.counselorFromForm(r, c, admin)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.renderCounselorPage(w, r, c, windows, form, admin)
+		return
+	}
+
+	if c.ID == 0 {
+		c.ID, err = m.DB.InsertCounselor(c)
+	} else {
+		err = m.DB.UpdateCounselor(c)
+	}
+
+	if helpers.Status(err) == http.StatusConflict {
+		form.Errors.Add("user-email", "This user account is already linked to another counselor")
+		m.renderCounselorPage(w, r, c, windows, form, admin)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ReplaceCounselorAvailability(c.ID, windows)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if before.ID == 0 {
+		m.audit(r, "counselor.create", fmt.Sprintf("counselor:%d", c.ID), nil, c)
+	} else {
+		m.audit(r, "counselor.update", fmt.Sprintf("counselor:%d", c.ID), before, c)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Counselor saved")
+	http.Redirect(w, r, counselorPath(c, admin), http.StatusSeeOther)
+}
+
+//postScheduleException adds a holiday or special hours to a counselor's schedule
+func (m *Repository) postScheduleException(w http.ResponseWriter, r *http.Request, c models.CounselingSession, admin bool) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("exception-start-date", "exception-end-date")
+
+	e := models.ScheduleException{
+		CounselingSessionID: c.ID,
+		Closed:              r.Form.Get("exception-closed") == "1",
+		Note:                r.Form.Get("exception-note"),
+	}
+
+	layout := "2006-01-02"
+	e.StartDate, err = time.Parse(layout, r.Form.Get("exception-start-date"))
+	if err != nil {
+		form.Errors.Add("exception-start-date", "Enter a valid date")
+	}
+	e.EndDate, err = time.Parse(layout, r.Form.Get("exception-end-date"))
+	if err != nil {
+		form.Errors.Add("exception-end-date", "Enter a valid date")
+	} else if e.EndDate.Before(e.StartDate) {
+		form.Errors.Add("exception-end-date", "The last day must not be before the first day")
+	}
+
+	if !e.Closed {
+		startMinute, startErr := parseClock(r.Form.Get("exception-start-time"))
+		endMinute, endErr := parseClock(r.Form.Get("exception-end-time"))
+		if startErr != nil || endErr != nil || endMinute <= startMinute {
+			form.Errors.Add("exception-start-time", "Enter the hours, or tick closed all day")
+		}
+		e.StartMinute, e.EndMinute = startMinute, endMinute
+	}
+
+	if !form.Valid() {
+		m.renderCounselorPage(w, r, c, nil, form, admin)
+		return
+	}
+
+	e.ID, err = m.DB.InsertScheduleException(e)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "counselor.exception_add", fmt.Sprintf("counselor:%d", c.ID), nil, e)
+
+	m.App.Session.Put(r.Context(), "flash", "Schedule updated")
+	http.Redirect(w, r, counselorPath(c, admin), http.StatusSeeOther)
+}
+
+//postDeleteScheduleException removes a holiday or special hours from a counselor's schedule
+func (m *Repository) postDeleteScheduleException(w http.ResponseWriter, r *http.Request, c models.CounselingSession, admin bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "eid"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteScheduleException(c.ID, id)
+	if helpers.Status(err) == http.StatusNotFound {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "counselor.exception_delete", fmt.Sprintf("counselor:%d", c.ID), map[string]int{"exception": id}, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Schedule updated")
+	http.Redirect(w, r, counselorPath(c, admin), http.StatusSeeOther)
+}
+
+//counselorFromURL gets the counselor whose id is in the URL
+func (m *Repository) counselorFromURL(w http.ResponseWriter, r *http.Request) (models.CounselingSession, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.CounselingSession{}, false
+	}
+
+	c, err := m.DB.GetCounselorByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return c, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return c, false
+	}
+
+	return c, true
+}
+
+//AdminCounselors lists the counselors
+func (m *Repository) AdminCounselors(w http.ResponseWriter, r *http.Request) {
+	counselors, err := m.DB.AllCounselors()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["counselors"] = counselors
+
+	render.Templates(w, r, "admin.counselors.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminNewCounselor shows the form to add a counselor
+func (m *Repository) AdminNewCounselor(w http.ResponseWriter, r *http.Request) {
+	m.renderCounselorPage(w, r, models.CounselingSession{Active: true}, nil, forms.New(nil), true)
+}
+
+//AdminPostNewCounselor adds a counselor
+func (m *Repository) AdminPostNewCounselor(w http.ResponseWriter, r *http.Request) {
+	m.postCounselor(w, r, models.CounselingSession{}, true)
+}
+
+//AdminShowCounselor shows a counselor's profile and schedule
+func (m *Repository) AdminShowCounselor(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.counselorFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.renderCounselorPage(w, r, c, nil, forms.New(nil), true)
+}
+
+//AdminPostShowCounselor saves a counselor's profile and weekly schedule
+func (m *Repository) AdminPostShowCounselor(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.counselorFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.postCounselor(w, r, c, true)
+}
+
+//AdminPostCounselorException adds a holiday or special hours for a counselor
+func (m *Repository) AdminPostCounselorException(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.counselorFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.postScheduleException(w, r, c, true)
+}
+
+//AdminPostDeleteCounselorException removes a holiday or special hours for a counselor
+func (m *Repository) AdminPostDeleteCounselorException(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.counselorFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.postDeleteScheduleException(w, r, c, true)
+}
+
+//currentCounselor gets the counselor linked to the logged in user
+func (m *Repository) currentCounselor(w http.ResponseWriter, r *http.Request) (models.CounselingSession, bool) {
+	c, err := m.DB.GetCounselorByUserID(m.App.Session.GetInt(r.Context(), "userId"))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Your account is not linked to a counselor")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return c, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return c, false
+	}
+
+	return c, true
+}
+
+//CounselorSchedule lets counselors manage their own profile and schedule
+func (m *Repository) CounselorSchedule(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.currentCounselor(w, r)
+	if !ok {
+		return
+	}
+	m.renderCounselorPage(w, r, c, nil, forms.New(nil), false)
+}
+
+//PostCounselorSchedule saves the logged in counselor's profile and weekly schedule
+func (m *Repository) PostCounselorSchedule(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.currentCounselor(w, r)
+	if !ok {
+		return
+	}
+	m.postCounselor(w, r, c, false)
+}
+
+//PostCounselorScheduleException adds a holiday or special hours for the logged in counselor
+func (m *Repository) PostCounselorScheduleException(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.currentCounselor(w, r)
+	if !ok {
+		return
+	}
+	m.postScheduleException(w, r, c, false)
+}
+
+//PostDeleteCounselorScheduleException removes a holiday or special hours for the logged in counselor
+func (m *Repository) PostDeleteCounselorScheduleException(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.currentCounselor(w, r)
+	if !ok {
+		return
+	}
+	m.postDeleteScheduleException(w, r, c, false)
+}
+
+//CounselorSessions lists the logged in counselor's own reservations, newest first
+func (m *Repository) CounselorSessions(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.currentCounselor(w, r)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	f := models.ReservationFilter{
+		CounselingSessionID: c.ID,
+		Sort:                "date",
+		Desc:                true,
+		Limit:               reservationsPerPage,
+		Offset:              (page - 1) * reservationsPerPage,
+	}
+
+	reservations, total, err := m.DB.FilterReservations(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	p := listPage{
+		Number: page,
+		Pages:  (total + reservationsPerPage - 1) / reservationsPerPage,
+		Total:  total,
+	}
+	if page > 1 {
+		p.PrevURL = pageURL(r, page-1)
+	}
+	if page < p.Pages {
+		p.NextURL = pageURL(r, page+1)
+	}
+
+	data := make(map[string]interface{})
+	data["counselor"] = c
+	data["reservations"] = reservations
+	data["page"] = p
+
+	render.Templates(w, r, "counselor-sessions.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//counselorReservation loads the reservation in the URL for its own counselor. Anyone else gets
+//a 403, so counselors can not open other counselors' clients
+func (m *Repository) counselorReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	if !m.isAssignedCounselor(r, res) {
+		helpers.ClientError(w, http.StatusForbidden)
+		return res, false
+	}
+
+	return res, true
+}
+
+//CounselorShowSession shows one of the logged in counselor's reservations with the client's intake answers
+//and the counselor's session notes
+func (m *Repository) CounselorShowSession(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.counselorReservation(w, r)
+	if !ok {
+		return
+	}
+
+	notes, err := m.openSessionNotes(r, res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	start, end := sessionTimes(res)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["start"] = start
+	data["end"] = end
+	data["notes"] = notes
+
+	render.Templates(w, r, "counselor-session.page.html", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+//intakeQuestionPath is the admin page of an intake question
+func intakeQuestionPath(q models.IntakeQuestion) string {
+	if q.ID == 0 {
+		return "/admin/intake/new"
+	}
+	return fmt.Sprintf("/admin/intake/%d", q.ID)
+}
+
+//validIntakeKey reports whether key only has lowercase letters, digits and underscores
+func validIntakeKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+//renderIntakeQuestionPage shows the form to add or change an intake question
+func (m *Repository) renderIntakeQuestionPage(w http.ResponseWriter, r *http.Request, q models.IntakeQuestion, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["action"] = intakeQuestionPath(q)
+
+	data := make(map[string]interface{})
+	data["question"] = q
+
+	render.Templates(w, r, "admin.intake-question.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+//postIntakeQuestion saves an intake question from the posted form
+func (m *Repository) postIntakeQuestion(w http.ResponseWriter, r *http.Request, q models.IntakeQuestion) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := q
+
+	form := forms.New(r.PostForm)
+	form.Required("key", "label", "kind")
+
+	q.Key = strings.TrimSpace(r.Form.Get("key"))
+	q.Label = strings.TrimSpace(r.Form.Get("label"))
+	q.Kind = r.Form.Get("kind")
+	q.Options = splitList(r.Form.Get("options"))
+	q.Required = r.Form.Get("required") == "1"
+	q.Active = r.Form.Get("active") == "1"
+	q.Position, _ = strconv.Atoi(r.Form.Get("position"))
+
+	if q.Key != "" && !validIntakeKey(q.Key) {
+		form.Errors.Add("key", "Use lowercase letters, digits and underscores only")
+	}
+
+	switch q.Kind {
+	case models.IntakeChoice:
+		if len(q.Options) < 2 {
+			form.Errors.Add("options", "Give at least two options, separated by commas")
+		}
+	case models.IntakeText, models.IntakeYesNo:
+		q.Options = nil
+	default:
+		form.Errors.Add("kind", "Choose what kind of answer the question takes")
+	}
+
+	if !form.Valid() {
+		m.renderIntakeQuestionPage(w, r, q, form)
+		return
+	}
+
+	if q.ID == 0 {
+		q.ID, err = m.DB.InsertIntakeQuestion(q)
+	} else {
+		err = m.DB.UpdateIntakeQuestion(q)
+	}
+
+	if helpers.Status(err) == http.StatusConflict {
+		form.Errors.Add("key", "Another question already uses this key")
+		m.renderIntakeQuestionPage(w, r, q, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if before.ID == 0 {
+		m.audit(r, "intake.question_create", fmt.Sprintf("intake_question:%d", q.ID), nil, q)
+	} else {
+		m.audit(r, "intake.question_update", fmt.Sprintf("intake_question:%d", q.ID), before, q)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Question saved")
+	http.Redirect(w, r, "/admin/intake", http.StatusSeeOther)
+}
+
+//intakeQuestionFromURL gets the intake question whose id is in the URL
+func (m *Repository) intakeQuestionFromURL(w http.ResponseWriter, r *http.Request) (models.IntakeQuestion, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.IntakeQuestion{}, false
+	}
+
+	q, err := m.DB.GetIntakeQuestionByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return q, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return q, false
+	}
+
+	return q, true
+}
+
+//AdminIntakeQuestions lists the questions on the counseling intake form
+func (m *Repository) AdminIntakeQuestions(w http.ResponseWriter, r *http.Request) {
+	questions, err := m.DB.IntakeQuestions(false)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["questions"] = questions
+
+	render.Templates(w, r, "admin.intake-questions.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminNewIntakeQuestion shows the form to add an intake question
+func (m *Repository) AdminNewIntakeQuestion(w http.ResponseWriter, r *http.Request) {
+	m.renderIntakeQuestionPage(w, r, models.IntakeQuestion{Kind: models.IntakeChoice, Active: true}, forms.New(nil))
+}
+
+//AdminPostNewIntakeQuestion adds an intake question
+func (m *Repository) AdminPostNewIntakeQuestion(w http.ResponseWriter, r *http.Request) {
+	m.postIntakeQuestion(w, r, models.IntakeQuestion{})
+}
+
+//AdminShowIntakeQuestion shows an intake question
+func (m *Repository) AdminShowIntakeQuestion(w http.ResponseWriter, r *http.Request) {
+	q, ok := m.intakeQuestionFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.renderIntakeQuestionPage(w, r, q, forms.New(nil))
+}
+
+//AdminPostShowIntakeQuestion saves an intake question. Questions are switched off rather than
+//deleted, so the answers already given keep their question
+func (m *Repository) AdminPostShowIntakeQuestion(w http.ResponseWriter, r *http.Request) {
+	q, ok := m.intakeQuestionFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.postIntakeQuestion(w, r, q)
+}
+
+//sessionNoteLimit is the longest session note in bytes
+const sessionNoteLimit = 20000
+
+//sessionNoteData ties a note's ciphertext to its reservation, so it can not be opened as a note
+//on another one
+func sessionNoteData(reservationID int) []byte {
+	return []byte(fmt.Sprintf("reservation:%d", reservationID))
+}
+
+//isAssignedCounselor reports whether the logged in user is the counselor of the reservation
+func (m *Repository) isAssignedCounselor(r *http.Request, res models.Reservation) bool {
+	userID := m.App.Session.GetInt(r.Context(), "userId")
+	return userID != 0 && res.CounselingSession.UserID == userID
+}
+
+//IsAdmin reports whether the logged in user has admin access
+func (m *Repository) IsAdmin(r *http.Request) (bool, error) {
+	userID := m.App.Session.GetInt(r.Context(), "userId")
+	if userID == 0 {
+		return false, nil
+	}
+
+	u, err := m.DB.GetUserByID(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return u.AccessLevel >= models.AccessLevelAdmin, nil
+}
+
+//canReadSessionNotes reports whether the logged in user may read the notes on a reservation.
+//Only its counselor and admins can
+func (m *Repository) canReadSessionNotes(r *http.Request, res models.Reservation) (bool, error) {
+	if m.isAssignedCounselor(r, res) {
+		return true, nil
+	}
+	return m.IsAdmin(r)
+}
+
+//openSessionNotes decrypts the notes on a reservation and records each read in the audit log.
+//Callers check that the user may read them first
+func (m *Repository) openSessionNotes(r *http.Request, reservationID int) ([]models.SessionNote, error) {
+	notes, err := m.DB.SessionNotes(reservationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, n := range notes {
+		notes[i].Ciphertext = nil
+
+		//One note that can not be decrypted should not hide the others
+		body, err := m.App.NotesKeyring.Open(n.KeyVersion, n.Ciphertext, sessionNoteData(reservationID))
+		if err != nil {
+			m.App.ErrorLog.Println("could not decrypt session note", n.ID, err)
+			notes[i].Body = unreadableNoteBody
+			continue
+		}
+		notes[i].Body = string(body)
+
+		m.audit(r, "session_note.read", fmt.Sprintf("session_note:%d", n.ID), nil, nil)
+	}
+
+	return notes, nil
+}
+
+//CounselorPostSessionNote adds a confidential note to one of the logged in counselor's reservations
+func (m *Repository) CounselorPostSessionNote(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.counselorReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+
+	showPage := fmt.Sprintf("/account/sessions/%d", id)
+
+	body := strings.TrimSpace(r.Form.Get("note"))
+	if body == "" {
+		m.App.Session.Put(r.Context(), "error", "The note is empty")
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+	if len(body) > sessionNoteLimit {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Notes can be at most %d characters", sessionNoteLimit))
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	version, ciphertext, err := m.App.NotesKeyring.Seal([]byte(body), sessionNoteData(id))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	note := models.SessionNote{
+		ReservationID: id,
+		AuthorID:      m.App.Session.GetInt(r.Context(), "userId"),
+		KeyVersion:    version,
+		Ciphertext:    ciphertext,
+	}
+
+	note.ID, err = m.DB.InsertSessionNote(note)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	//The audit log only records that a note was written, never what it says
+	m.audit(r, "session_note.create", fmt.Sprintf("session_note:%d", note.ID), nil,
+		map[string]int{"reservationId": id, "keyVersion": version})
+
+	m.App.Session.Put(r.Context(), "flash", "Note saved")
+	http.Redirect(w, r, showPage, http.StatusSeeOther)
+}
+
+//sessionNoteRotationBatch is how many notes are encrypted again at a time
+const sessionNoteRotationBatch = 100
+
+//unreadableNoteBody is shown in place of a session note that can not be decrypted
+const unreadableNoteBody = "This note can not be read. It was encrypted with a key that is no longer available"
+
+//RotateSessionNotes encrypts the notes written with older keys again with the newest key, so old
+//keys can be retired. Notes sealed with a key the keyring does not have are skipped. It returns how
+//many notes it changed
+func (m *Repository) RotateSessionNotes() (int, error) {
+	keyring := m.App.NotesKeyring
+	rotated := 0
+	afterID := 0
+
+	for {
+		notes, err := m.DB.SessionNotesToRotate(keyring.Current(), afterID, sessionNoteRotationBatch)
+		if err != nil {
+			return rotated, err
+		}
+		if len(notes) == 0 {
+			return rotated, nil
+		}
+
+		for _, n := range notes {
+			afterID = n.ID
+
+			version, ciphertext, err := keyring.Rotate(n.KeyVersion, n.Ciphertext, sessionNoteData(n.ReservationID))
+			if errors.Is(err, vault.ErrUnknownKey) {
+				//Notes sealed with a key that was removed stay as they are
+				m.App.ErrorLog.Println("Skipping session note", n.ID, err)
+				continue
+			} else if err != nil {
+				return rotated, fmt.Errorf("could not re-encrypt session note %d: %w", n.ID, err)
+			}
+
+			err = m.DB.UpdateSessionNoteCiphertext(n.ID, n.KeyVersion, version, ciphertext)
+			if err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+}
+
+//counselorSuggestions ranks the other counselors free at a reservation's time for its client
+func (m *Repository) counselorSuggestions(res models.Reservation) ([]matching.Match, error) {
+	start, _ := sessionTimes(res)
+
+	candidates, err := m.matchCandidates(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var others []matching.Candidate
+	for _, c := range candidates {
+		if c.Counselor.ID != res.CounselingSessionID {
+			others = append(others, c)
+		}
+	}
+
+	return matching.Rank(matching.NewRequest(res), others), nil
+}
+
+//AdminPostReservationCounselor overrides the counselor a reservation was matched with
+func (m *Repository) AdminPostReservationCounselor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	showPage := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+
+	if len(models.NextReservationStatuses(res.Status)) == 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can not be given to another counselor",
+			strings.ToLower(models.ReservationStatusLabel(res.Status))))
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	counselingSessionID, _ := strconv.Atoi(r.Form.Get("counseling-session-id"))
+	if counselingSessionID == 0 || counselingSessionID == res.CounselingSessionID {
+		m.App.Session.Put(r.Context(), "error", "Choose another counselor")
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	c, err := m.DB.GetCounselorByID(counselingSessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Choose another counselor")
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	notFree := fmt.Sprintf("%s is not free at this time", c.CounselorName)
+
+	start, _ := sessionTimes(res)
+
+	_, ok, err := m.offeredSlot(c.ID, start)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", notFree)
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ReassignReservation(id, c.ID)
+	if helpers.Status(err) == http.StatusConflict {
+		m.App.Session.Put(r.Context(), "error", notFree)
+		http.Redirect(w, r, showPage, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "reservation.reassign", fmt.Sprintf("reservation:%d", id),
+		map[string]interface{}{"counselingSessionId": res.CounselingSessionID, "counselor": res.CounselingSession.CounselorName},
+		map[string]interface{}{"counselingSessionId": c.ID, "counselor": c.CounselorName})
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation given to %s", c.CounselorName))
+
+	previous := res.CounselingSessionID
+	res.CounselingSessionID = c.ID
+	res.CounselingSession = c
+
+	//The reservation already has its new counselor, so failed emails are only logged and shown as a warning
+	msg, err := m.sessionMail(res, counselorChangedMail)
+	if err == nil {
+		err = m.queueMail(msg)
+	}
+	if err == nil {
+		err = m.notifyCounselor(res, previous, counselorUnassigned, start)
+	}
+	if err == nil {
+		err = m.notifyCounselor(res, c.ID, counselorAssigned, start)
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("Error sending counselor change emails for reservation", res.ID, err)
+		m.App.Session.Put(r.Context(), "warning", "Not everyone could be emailed about this change")
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as complete")
-	http.Redirect(w, r, fmt.Sprintf("/admin/%s-reservations", src), http.StatusSeeOther)
+	http.Redirect(w, r, showPage, http.StatusSeeOther)
 }
@@ -1,9 +1,23 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"server/everydaymuslimappserver/internal/forms"
+	"server/everydaymuslimappserver/internal/models"
+	"server/everydaymuslimappserver/internal/vault"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
 )
 
 type postData struct {
@@ -32,6 +46,41 @@ var theTests = []struct {
 	{"Get One ayah with week that does not exist", "/ayahs/1000000", "GET", http.StatusNotFound},
 	{"Get One dua with ID that does not exist", "/duas/1000000", "GET", http.StatusNotFound},
 	{"Get One surah with ID that does not exist", "/surahs/1000000", "GET", http.StatusNotFound},
+	{"newsletter signup", "/signup", "GET", http.StatusOK},
+	{"confirm newsletter", "/newsletter/confirm?token=abc", "GET", http.StatusOK},
+	{"confirm newsletter with invalid token", "/newsletter/confirm?token=invalid", "GET", http.StatusOK},
+	{"admin privacy", "/admin/privacy", "GET", http.StatusOK},
+	{"admin audit", "/admin/audit?action=auth&from=2021-01-01", "GET", http.StatusOK},
+	{"admin audit export", "/admin/audit/export", "GET", http.StatusOK},
+	{"admin outbox", "/admin/outbox?status=dead", "GET", http.StatusOK},
+	{"admin outbox retry unknown", "/admin/outbox/9999/retry", "GET", http.StatusOK},
+	{"admin email templates", "/admin/email-templates", "GET", http.StatusOK},
+	{"counseling reservation", "/counseling-reservation", "GET", http.StatusOK},
+	{"counseling reservation success without reservation", "/counseling-reservation-success", "GET", http.StatusOK},
+	{"admin counselors", "/admin/counselors", "GET", http.StatusOK},
+	{"admin new counselor", "/admin/counselors/new", "GET", http.StatusOK},
+	{"admin show counselor", "/admin/counselors/1", "GET", http.StatusOK},
+	{"admin show reservation", "/admin/reservations/all/1", "GET", http.StatusOK},
+	{"admin show reservation from calendar", "/admin/reservations/cal/1", "GET", http.StatusOK},
+	{"admin show reservation needing a counselor", "/admin/reservations/new/8", "GET", http.StatusOK},
+	{"admin reservations report", "/admin/reservations/report?status=confirmed&counselor=1&from=2021-05-01&to=2021-05-31", "GET", http.StatusOK},
+	{"admin dashboard", "/admin/dashboard", "GET", http.StatusOK},
+	{"admin calendar", "/admin/calendar?date=2021-05-03&view=week", "GET", http.StatusOK},
+	{"admin show unknown counselor", "/admin/counselors/9999", "GET", http.StatusNotFound},
+	{"admin intake questions", "/admin/intake", "GET", http.StatusOK},
+	{"admin new intake question", "/admin/intake/new", "GET", http.StatusOK},
+	{"admin show intake question", "/admin/intake/1", "GET", http.StatusOK},
+	{"admin show unknown intake question", "/admin/intake/9999", "GET", http.StatusNotFound},
+	{"admin email template preview", "/admin/email-templates/newsletter-confirm.email.html", "GET", http.StatusOK},
+	{"admin email template text", "/admin/email-templates/campaign.email.html?format=text", "GET", http.StatusOK},
+	{"admin email template unknown", "/admin/email-templates/missing.email.html", "GET", http.StatusNotFound},
+	{"admin subscribers", "/admin/subscribers?status=confirmed&tag=1", "GET", http.StatusOK},
+	{"admin subscribers export", "/admin/subscribers/export?from=2021-01-01", "GET", http.StatusOK},
+	{"admin campaigns", "/admin/campaigns", "GET", http.StatusOK},
+	{"admin new campaign", "/admin/campaigns/new", "GET", http.StatusOK},
+	{"admin show campaign", "/admin/campaigns/1", "GET", http.StatusOK},
+	{"admin show missing campaign", "/admin/campaigns/1000", "GET", http.StatusNotFound},
+	{"admin preview campaign", "/admin/campaigns/1/preview", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -71,3 +120,1362 @@ func TestHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestPersonalDataFor(t *testing.T) {
+	pd, err := Repo.personalDataFor("me@here.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pd.Profile == nil || len(pd.Activity) != 1 || pd.Activity[0].IPAddress != "192.0.2.1" {
+		t.Errorf("expected the profile and its sign-in activity, got %+v", pd)
+	}
+
+	pd, err = Repo.personalDataFor("notfound@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pd.Profile != nil || len(pd.Activity) != 0 {
+		t.Errorf("expected no profile or activity without an account, got %+v", pd)
+	}
+}
+
+func TestAuditDiff(t *testing.T) {
+	before := models.Reservation{ID: 1, FirstName: "Maryam", Status: models.ReservationRequested,
+		Answers: []models.IntakeAnswer{{QuestionKey: models.IntakeTopic, Answer: "Family"}}}
+	after := before
+	after.Status = models.ReservationConfirmed
+	after.Answers = []models.IntakeAnswer{{QuestionKey: models.IntakeTopic, Answer: "Grief"}}
+
+	var tests = []struct {
+		name           string
+		before         interface{}
+		after          interface{}
+		expectedBefore string
+		expectedAfter  string
+	}{
+		{"changed status", before, after, `{"Status":"requested"}`, `{"Status":"confirmed"}`},
+		{"nothing changed", before, before, "", ""},
+		{"created", nil, map[string]string{"status": "draft", "password": "secret"}, "", `{"status":"draft"}`},
+		{"not an object", nil, []int{1, 2}, "", "[1,2]"},
+	}
+
+	for _, tt := range tests {
+		b, a := auditDiff(tt.before, tt.after)
+		if b != tt.expectedBefore || a != tt.expectedAfter {
+			t.Errorf("%s: expected %s and %s but got %s and %s", tt.name, tt.expectedBefore, tt.expectedAfter, b, a)
+		}
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	digest := emailDigest("Maryam@Example.com ")
+
+	if strings.Contains(strings.ToLower(digest), "maryam") {
+		t.Errorf("expected the digest to hide the address, got %q", digest)
+	}
+	if digest != emailDigest("maryam@example.com") {
+		t.Error("expected the same digest for the same address")
+	}
+	if digest == emailDigest("john@example.com") {
+		t.Error("expected different addresses to have different digests")
+	}
+}
+
+func TestWritePersonalDataExport(t *testing.T) {
+	pd := models.PersonalData{
+		ExportedAt: time.Now(),
+		Email:      "me@here.com",
+	}
+
+	rr := httptest.NewRecorder()
+	err := writePersonalDataExport(rr, pd, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON export but got %s", rr.Header().Get("Content-Type"))
+	}
+
+	rr = httptest.NewRecorder()
+	err = writePersonalDataExport(rr, pd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := rr.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal("export is not a valid zip file", err)
+	}
+
+	if len(zr.File) != 1 || zr.File[0].Name != "personal-data.json" {
+		t.Error("zip export should hold only personal-data.json")
+	}
+}
+
+func TestNewsletterUnsubscribe(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	msg, err := Repo.campaignMail(models.NewsletterSubscriber{ID: 1, Email: "subscribed@example.com"},
+		models.Campaign{Subject: "Subject", Body: "<p>Body</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(msg.TextContent, "/newsletter/unsubscribe?id=1&sig=") {
+		t.Error("newsletter mail text is missing the unsubscribe link")
+	}
+
+	if msg.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Error("newsletter mail is missing the List-Unsubscribe-Post header")
+	}
+
+	link := strings.TrimSuffix(strings.TrimPrefix(msg.Headers["List-Unsubscribe"], "<"), ">")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + u.RequestURI())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for unsubscribe page but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	values := url.Values{}
+	values.Add("List-Unsubscribe", "One-Click")
+
+	resp, err = ts.Client().PostForm(ts.URL+u.RequestURI(), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for one-click unsubscribe but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = ts.Client().PostForm(ts.URL+"/newsletter/unsubscribe?id=1&sig=forged", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for forged unsubscribe link but got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestParseSubscriberCSV(t *testing.T) {
+	data := `First Name,Last Name,Email
+Aisha,Khan,aisha@example.com
+Bilal,Ahmed,BILAL@example.com
+Bilal,Ahmed,bilal@example.com
+Nobody,,not-an-email
+`
+
+	result, err := parseSubscriberCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Subscribers) != 2 {
+		t.Fatalf("expected 2 subscribers but got %d", len(result.Subscribers))
+	}
+
+	if result.Subscribers[0].FirstName != "Aisha" || result.Subscribers[1].Email != "bilal@example.com" {
+		t.Error("columns were not read from the header row")
+	}
+
+	if result.Duplicates != 1 {
+		t.Errorf("expected 1 duplicate but got %d", result.Duplicates)
+	}
+
+	if len(result.Invalid) != 1 || !strings.HasPrefix(result.Invalid[0], "line 5") {
+		t.Errorf("expected line 5 to be invalid but got %v", result.Invalid)
+	}
+
+	result, err = parseSubscriberCSV(strings.NewReader("omar@example.com,Omar,Farooq\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Subscribers) != 1 || result.Subscribers[0].LastName != "Farooq" {
+		t.Error("file without a header row should use email, first name, last name columns")
+	}
+}
+
+func TestPostNewsLetterSignUp(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	testMailer.Reset()
+
+	values := url.Values{}
+	values.Add("first-name", "<b>Maryam</b>")
+	values.Add("last-name", "Yusuf")
+	values.Add("email", "maryam@example.com")
+
+	resp, err := ts.Client().PostForm(ts.URL+"/signup", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	sent := waitForMail(1)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 confirmation email but got %d", len(sent))
+	}
+
+	if sent[0].To != "maryam@example.com" || !strings.Contains(sent[0].Content, "/newsletter/confirm?token=") {
+		t.Error("confirmation email does not hold the confirmation link")
+	}
+
+	if sent[0].Headers["List-Unsubscribe"] == "" {
+		t.Error("confirmation email is missing the List-Unsubscribe header")
+	}
+
+	if !strings.Contains(sent[0].Content, "Dear &lt;b&gt;Maryam&lt;/b&gt; Yusuf") {
+		t.Error("names in the confirmation email are not escaped")
+	}
+
+	if !strings.Contains(sent[0].TextContent, "Dear <b>Maryam</b> Yusuf") {
+		t.Error("confirmation email is missing its plain text part")
+	}
+
+	testMailer.Reset()
+
+	values.Set("email", "subscribed@example.com")
+
+	resp, err = ts.Client().PostForm(ts.URL+"/signup", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if len(waitForMail(0)) != 0 {
+		t.Error("no email should be sent to an address that is already subscribed")
+	}
+}
+
+func TestEmailTemplateSamples(t *testing.T) {
+	samples := make(map[string]bool)
+	for _, sample := range Repo.emailTemplateSamples() {
+		samples[sample.Name] = true
+	}
+
+	for name := range app.EmailTemplateCache {
+		if !samples[name] {
+			t.Errorf("email template %s has no sample data for /admin/email-templates", name)
+		}
+	}
+
+	for name := range samples {
+		if _, ok := app.EmailTemplateCache[name]; !ok {
+			t.Errorf("sample %s has no email template", name)
+		}
+	}
+}
+
+func TestAdminPostSendTestEmail(t *testing.T) {
+	testMailer.Reset()
+
+	req, _ := http.NewRequest("POST", "/admin/email-templates/campaign.email.html/send", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "userId", 1)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("name", "campaign.email.html")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostSendTestEmail).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	sent := testMailer.Messages()
+	if len(sent) != 1 || sent[0].To != "admin@example.com" || !strings.HasPrefix(sent[0].Subject, "[Test]") {
+		t.Errorf("expected one test email to the admin, got %+v", sent)
+	}
+}
+
+func TestAdminPostShowCounselor(t *testing.T) {
+	var tests = []struct {
+		name       string
+		values     url.Values
+		statusCode int
+	}{
+		{"valid", url.Values{"counselor-name": {"Session1"}, "user-email": {"admin@example.com"},
+			"start-1": {"09:00"}, "end-1": {"17:00"}}, http.StatusSeeOther},
+		{"missing name", url.Values{"start-1": {"09:00"}, "end-1": {"17:00"}}, http.StatusOK},
+		{"end before start", url.Values{"counselor-name": {"Session1"}, "start-1": {"17:00"}, "end-1": {"09:00"}}, http.StatusOK},
+		{"unknown user", url.Values{"counselor-name": {"Session1"}, "user-email": {"notfound@example.com"}}, http.StatusOK},
+		{"unknown gender", url.Values{"counselor-name": {"Session1"}, "gender": {"other"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/counselors/1", strings.NewReader(tt.values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostShowCounselor).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+	}
+}
+
+func TestCounselorSessions(t *testing.T) {
+	var tests = []struct {
+		name       string
+		userID     int
+		statusCode int
+	}{
+		{"linked counselor", 1, http.StatusOK},
+		{"not a counselor", 3, http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/account/sessions", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", tt.userID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.CounselorSessions).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+	}
+}
+
+func TestCounselorShowSession(t *testing.T) {
+	var tests = []struct {
+		name       string
+		userID     int
+		id         string
+		statusCode int
+	}{
+		{"assigned counselor", 2, "5", http.StatusOK},
+		{"other counselor", 3, "5", http.StatusForbidden},
+		{"admin", 1, "5", http.StatusForbidden},
+		{"unknown reservation", 2, "1000", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/account/sessions/"+tt.id, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", tt.userID)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.id)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.CounselorShowSession).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+		if tt.statusCode == http.StatusOK && !strings.Contains(rr.Body.String(), "Family") {
+			t.Errorf("%s: expected the client's intake answers", tt.name)
+		}
+	}
+}
+
+func TestAdminPostIntakeQuestion(t *testing.T) {
+	var tests = []struct {
+		name       string
+		id         string
+		values     url.Values
+		statusCode int
+	}{
+		{"valid", "2", url.Values{"key": {"urgency"}, "label": {"How urgent is it?"}, "kind": {"choice"},
+			"options": {"Not urgent, Urgent"}, "required": {"1"}, "active": {"1"}}, http.StatusSeeOther},
+		{"missing label", "2", url.Values{"key": {"urgency"}, "kind": {"yes_no"}}, http.StatusOK},
+		{"bad key", "2", url.Values{"key": {"How Urgent"}, "label": {"How urgent is it?"}, "kind": {"yes_no"}}, http.StatusOK},
+		{"unknown kind", "2", url.Values{"key": {"urgency"}, "label": {"How urgent is it?"}, "kind": {"scale"}}, http.StatusOK},
+		{"choice without options", "2", url.Values{"key": {"urgency"}, "label": {"How urgent is it?"}, "kind": {"choice"}}, http.StatusOK},
+		{"key taken", "2", url.Values{"key": {"topic"}, "label": {"How urgent is it?"}, "kind": {"yes_no"}}, http.StatusOK},
+		{"unknown question", "9999", url.Values{"key": {"urgency"}, "label": {"How urgent is it?"}, "kind": {"yes_no"}}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/intake/"+tt.id, strings.NewReader(tt.values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.id)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostShowIntakeQuestion).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+	}
+}
+
+func TestIntakeAnswers(t *testing.T) {
+	questions := []models.IntakeQuestion{
+		{ID: 1, Key: "topic", Label: "Topic", Kind: models.IntakeChoice, Options: []string{"Family", "Grief"}, Required: true},
+		{ID: 2, Key: "same_gender", Label: "Same gender", Kind: models.IntakeYesNo, Required: true},
+		{ID: 3, Key: "reason", Label: "Reason", Kind: models.IntakeText},
+	}
+
+	var tests = []struct {
+		name    string
+		values  url.Values
+		answers int
+		errors  []string
+	}{
+		{"all answered", url.Values{"intake-topic": {"Grief"}, "intake-same_gender": {"no"}, "intake-reason": {"Loss"}}, 3, nil},
+		{"optional left out", url.Values{"intake-topic": {"Family"}, "intake-same_gender": {"yes"}}, 2, nil},
+		{"required missing", url.Values{"intake-reason": {"Loss"}}, 1, []string{"intake-topic", "intake-same_gender"}},
+		{"unknown option", url.Values{"intake-topic": {"Work"}, "intake-same_gender": {"maybe"}}, 0, []string{"intake-topic", "intake-same_gender"}},
+	}
+
+	for _, tt := range tests {
+		form := forms.New(tt.values)
+		answers := intakeAnswers(questions, form)
+
+		if len(answers) != tt.answers {
+			t.Errorf("%s: expected %d answers but got %d", tt.name, tt.answers, len(answers))
+		}
+		for _, field := range tt.errors {
+			if form.Errors.Get(field) == "" {
+				t.Errorf("%s: expected an error on %s", tt.name, field)
+			}
+		}
+		if len(tt.errors) == 0 && !form.Valid() {
+			t.Errorf("%s: expected no errors, got %v", tt.name, form.Errors)
+		}
+	}
+}
+
+func TestAdminReservationLists(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	var tests = []struct {
+		name       string
+		url        string
+		statusCode int
+		expected   string
+	}{
+		{"all", "/admin/all-reservations", http.StatusOK, "Showing 1 to 25 of 30 reservations"},
+		{"all second page", "/admin/all-reservations?page=2", http.StatusOK, "Showing 26 to 30 of 30 reservations"},
+		{"all past the last page", "/admin/all-reservations?page=9", http.StatusOK, "No reservations found"},
+		{"confirmed", "/admin/all-reservations?status=confirmed", http.StatusOK, "Showing 1 to 10 of 10 reservations"},
+		{"date range", "/admin/all-reservations?from=2021-05-10&to=2021-05-16", http.StatusOK, "Showing 1 to 7 of 7 reservations"},
+		{"search", "/admin/all-reservations?q=client12%40example.com", http.StatusOK, "Showing 1 to 1 of 1 reservations"},
+		{"newest first", "/admin/all-reservations?sort=date&dir=desc", http.StatusOK, "/admin/reservations/all/30"},
+		{"new", "/admin/new-reservations", http.StatusOK, "Showing 1 to 20 of 20 reservations"},
+		{"new ignores status", "/admin/new-reservations?status=confirmed", http.StatusOK, "Showing 1 to 20 of 20 reservations"},
+		{"database error", "/admin/all-reservations?counselor=200000", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		resp, err := ts.Client().Get(ts.URL + tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body bytes.Buffer
+		_, _ = body.ReadFrom(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, resp.StatusCode)
+		}
+
+		if !strings.Contains(body.String(), tt.expected) {
+			t.Errorf("%s: expected the page to contain %q", tt.name, tt.expected)
+		}
+	}
+}
+
+func TestNewReservationReport(t *testing.T) {
+	reservations, _, _ := Repo.DB.FilterReservations(models.ReservationFilter{})
+	reservations = append(reservations, models.Reservation{
+		StartTime: time.Date(2021, 5, 31, 10, 0, 0, 0, time.Local),
+		Status:    models.ReservationNoShow,
+	})
+
+	report := newReservationReport(reservations)
+
+	if report.Total != 31 {
+		t.Errorf("expected 31 reservations but got %d", report.Total)
+	}
+
+	expected := reservationReport{
+		ByCounselor: []reportTotal{{"Session1", 30}, {"Unassigned", 1}},
+		ByStatus:    []reportTotal{{"Requested", 20}, {"Confirmed", 10}, {"No show", 1}},
+		ByWeek: []reportTotal{{"2021-04-26", 2}, {"2021-05-03", 7}, {"2021-05-10", 7},
+			{"2021-05-17", 7}, {"2021-05-24", 7}, {"2021-05-31", 1}},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		got      []reportTotal
+		expected []reportTotal
+	}{
+		{"counselor", report.ByCounselor, expected.ByCounselor},
+		{"status", report.ByStatus, expected.ByStatus},
+		{"week", report.ByWeek, expected.ByWeek},
+	} {
+		if len(tt.got) != len(tt.expected) {
+			t.Errorf("per %s: expected %v but got %v", tt.name, tt.expected, tt.got)
+			continue
+		}
+		for i := range tt.got {
+			if tt.got[i] != tt.expected[i] {
+				t.Errorf("per %s: expected %v but got %v", tt.name, tt.expected, tt.got)
+				break
+			}
+		}
+	}
+}
+
+func TestAdminReservationsExport(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/admin/reservations/export?status=confirmed&page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("expected a CSV file but got %s", resp.Header.Get("Content-Type"))
+	}
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 11 {
+		t.Fatalf("expected a header and every one of the 10 confirmed reservations but got %d rows", len(records))
+	}
+
+	if records[1][3] != "client3@example.com" || records[1][4] != "Session1" || records[1][5] != models.ReservationConfirmed {
+		t.Errorf("unexpected first row %v", records[1])
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	monday := time.Date(2021, 5, 3, 0, 0, 0, 0, time.Local)
+
+	for _, day := range []time.Time{
+		monday,
+		time.Date(2021, 5, 5, 13, 30, 0, 0, time.Local),
+		time.Date(2021, 5, 9, 23, 59, 0, 0, time.Local),
+	} {
+		if got := weekStart(day); !got.Equal(monday) {
+			t.Errorf("%s: expected the week to start on %s but got %s", day, monday, got)
+		}
+	}
+}
+
+func TestAdminDashboardJSON(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	var tests = []struct {
+		name  string
+		query string
+		weeks int
+	}{
+		{"default", "", dashboardWeeks},
+		{"quarter", "?weeks=13", 13},
+		{"too many", "?weeks=500", dashboardWeeks},
+		{"not a number", "?weeks=all", dashboardWeeks},
+	}
+
+	for _, tt := range tests {
+		resp, err := ts.Client().Get(ts.URL + "/admin/api/dashboard" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stats models.DashboardStats
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if resp.StatusCode != http.StatusOK || len(stats.ReservationsPerWeek) != tt.weeks {
+			t.Errorf("%s: expected %d weeks but got %d with %d weeks", tt.name, tt.weeks, resp.StatusCode, len(stats.ReservationsPerWeek))
+			continue
+		}
+
+		last := stats.ReservationsPerWeek[len(stats.ReservationsPerWeek)-1].Week
+		if !last.Equal(weekStart(time.Now())) {
+			t.Errorf("%s: expected the last week to be this week but got %s", tt.name, last)
+		}
+
+		if stats.CompletionRate != 0.75 || len(stats.TopContent) != 2 {
+			t.Errorf("%s: unexpected stats %+v", tt.name, stats)
+		}
+	}
+}
+
+func TestAdminReservationsJSON(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	var tests = []struct {
+		name       string
+		query      string
+		statusCode int
+		kinds      []string
+	}{
+		{"month", "start=2021-04-25&end=2021-06-06", http.StatusOK, []string{"reservation", "block"}},
+		{"week without bookings", "start=2021-05-09&end=2021-05-16", http.StatusOK, []string{}},
+		{"one counselor", "start=2021-05-02&end=2021-05-09&counseling-session-id=1", http.StatusOK, []string{"reservation", "block"}},
+		{"end before start", "start=2021-05-09&end=2021-05-02", http.StatusBadRequest, []string{}},
+		{"too long", "start=2021-01-01&end=2021-06-01", http.StatusBadRequest, []string{}},
+		{"missing dates", "", http.StatusBadRequest, []string{}},
+		{"database error", "start=2021-05-02&end=2021-05-09&counseling-session-id=200000", http.StatusInternalServerError, []string{}},
+	}
+
+	for _, tt := range tests {
+		resp, err := ts.Client().Get(ts.URL + "/admin/api/reservations?" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var j calendarResponse
+		err = json.NewDecoder(resp.Body).Decode(&j)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if resp.StatusCode != tt.statusCode || len(j.Events) != len(tt.kinds) {
+			t.Errorf("%s: expected %d with %d events but got %d with %d events",
+				tt.name, tt.statusCode, len(tt.kinds), resp.StatusCode, len(j.Events))
+			continue
+		}
+
+		for i, kind := range tt.kinds {
+			if j.Events[i].Kind != kind {
+				t.Errorf("%s: expected event %d to be a %s but got %s", tt.name, i, kind, j.Events[i].Kind)
+			}
+		}
+
+		if len(j.Events) > 0 && j.Events[0].URL != "/admin/reservations/cal/1" {
+			t.Errorf("%s: expected the booking to link to its reservation but got %q", tt.name, j.Events[0].URL)
+		}
+	}
+}
+
+func TestAdminPostCalendarBlock(t *testing.T) {
+	var tests = []struct {
+		name     string
+		values   url.Values
+		location string
+		message  string
+	}{
+		{"valid", url.Values{"counseling-session-id": {"1"}, "date": {"2021-05-03"}, "start-time": {"09:00"}, "end-time": {"11:00"}},
+			"/admin/calendar?date=2021-05-03", "flash"},
+		{"overlaps a booking", url.Values{"counseling-session-id": {"1"}, "date": {"2021-05-03"}, "start-time": {"16:00"}, "end-time": {"17:00"}},
+			"/admin/calendar?date=2021-05-03", "error"},
+		{"end before start", url.Values{"counseling-session-id": {"1"}, "date": {"2021-05-03"}, "start-time": {"11:00"}, "end-time": {"09:00"}},
+			"/admin/calendar", "error"},
+		{"missing counselor", url.Values{"date": {"2021-05-03"}, "start-time": {"09:00"}, "end-time": {"11:00"}},
+			"/admin/calendar", "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/calendar/blocks", strings.NewReader(tt.values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostCalendarBlock).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expected a redirect to %s but got %d to %s", tt.name, tt.location, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if session.PopString(ctx, tt.message) == "" {
+			t.Errorf("%s: expected a %s message", tt.name, tt.message)
+		}
+	}
+
+	for _, tt := range []struct {
+		id         string
+		statusCode int
+	}{{"2", http.StatusSeeOther}, {"9999", http.StatusNotFound}} {
+		req, _ := http.NewRequest("POST", "/admin/calendar/blocks/"+tt.id+"/delete", strings.NewReader("date=2021-05-03"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.id)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostDeleteCalendarBlock).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("delete block %s: expected %d but got %d", tt.id, tt.statusCode, rr.Code)
+		}
+	}
+}
+
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+func TestAdminPostReservationStatus(t *testing.T) {
+	tuesday := nextMonday().AddDate(0, 0, 1).Format("2006-01-02")
+
+	var tests = []struct {
+		name       string
+		id         string
+		values     url.Values
+		statusCode int
+		location   string
+		method     string
+	}{
+		{"confirm", "1", url.Values{"status": {"confirmed"}}, http.StatusSeeOther, "/admin/new-reservations", "REQUEST"},
+		{"reschedule", "1", url.Values{"status": {"rescheduled"}, "date": {tuesday}, "start-time": {"10:00"}},
+			http.StatusSeeOther, "/admin/new-reservations", "REQUEST"},
+		{"cancel", "1", url.Values{"status": {"cancelled_by_staff"}}, http.StatusSeeOther, "/admin/new-reservations", "CANCEL"},
+		{"complete before confirming", "1", url.Values{"status": {"completed"}}, http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"confirm a completed reservation", "2", url.Values{"status": {"confirmed"}}, http.StatusSeeOther, "/admin/reservations/new/2", ""},
+		{"reschedule without a time", "1", url.Values{"status": {"rescheduled"}}, http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"reschedule to a taken slot", "1", url.Values{"status": {"rescheduled"}, "date": {tuesday}, "start-time": {"16:00"}},
+			http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"reschedule to a busy time", "1", url.Values{"status": {"rescheduled"}, "date": {tuesday}, "start-time": {"12:00"}},
+			http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"reschedule outside working hours", "1", url.Values{"status": {"rescheduled"}, "date": {tuesday}, "start-time": {"20:00"}},
+			http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"reschedule to the past", "1", url.Values{"status": {"rescheduled"}, "date": {"2021-05-04"}, "start-time": {"10:00"}},
+			http.StatusSeeOther, "/admin/reservations/new/1", ""},
+		{"unknown reservation", "9999", url.Values{"status": {"confirmed"}}, http.StatusNotFound, "", ""},
+		{"email fails", "100", url.Values{"status": {"confirmed"}}, http.StatusSeeOther, "/admin/new-reservations", ""},
+	}
+
+	for _, tt := range tests {
+		testMailer.Reset()
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/new/"+tt.id+"/status", strings.NewReader(tt.values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "new")
+		rctx.URLParams.Add("id", tt.id)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostReservationStatus).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode || rr.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expected %d to %q but got %d to %q", tt.name, tt.statusCode, tt.location, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if tt.id == "100" && session.GetString(ctx, "warning") == "" {
+			t.Errorf("%s: expected a warning that the client was not emailed", tt.name)
+		}
+
+		if tt.method == "" {
+			time.Sleep(50 * time.Millisecond)
+			if sent := testMailer.Messages(); len(sent) != 0 {
+				t.Errorf("%s: expected no email but got %d", tt.name, len(sent))
+			}
+			continue
+		}
+
+		sent := waitForMail(1)
+		if len(sent) != 1 || len(sent[0].Attachments) != 1 {
+			t.Fatalf("%s: expected one email with an invite attached", tt.name)
+		}
+
+		invite := string(sent[0].Attachments[0].Data)
+		if !strings.Contains(invite, "METHOD:"+tt.method+"\r\n") || !strings.Contains(invite, "UID:reservation-1@") {
+			t.Errorf("%s: unexpected invite %q", tt.name, invite)
+		}
+	}
+}
+
+//postSessionNote posts a note on a reservation as the given user
+func postSessionNote(userID int, id, note string) *httptest.ResponseRecorder {
+	values := url.Values{"note": {note}}
+	req, _ := http.NewRequest("POST", "/account/sessions/"+id+"/notes", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	session.Put(ctx, "userId", userID)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.CounselorPostSessionNote).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCounselorPostSessionNote(t *testing.T) {
+	var tests = []struct {
+		name       string
+		userID     int
+		id         string
+		note       string
+		statusCode int
+		saved      bool
+	}{
+		{"assigned counselor", 2, "5", "Client is sleeping better", http.StatusSeeOther, true},
+		{"admin", 1, "5", "Admin note", http.StatusForbidden, false},
+		{"other user", 3, "5", "Other note", http.StatusForbidden, false},
+		{"empty note", 2, "5", "  ", http.StatusSeeOther, false},
+		{"unknown reservation", 2, "1000", "Note", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		before, _ := Repo.DB.SessionNotes(5)
+
+		rr := postSessionNote(tt.userID, tt.id, tt.note)
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+
+		after, _ := Repo.DB.SessionNotes(5)
+		if saved := len(after) > len(before); saved != tt.saved {
+			t.Errorf("%s: expected saved to be %v", tt.name, tt.saved)
+		}
+		if tt.saved && bytes.Contains(after[len(after)-1].Ciphertext, []byte(tt.note)) {
+			t.Errorf("%s: expected the note to be stored encrypted", tt.name)
+		}
+	}
+}
+
+func TestShowReservationNotes(t *testing.T) {
+	if rr := postSessionNote(2, "6", "Discussed family matters"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("could not save a note, got %d", rr.Code)
+	}
+
+	var tests = []struct {
+		name       string
+		url        string
+		handler    http.HandlerFunc
+		userID     int
+		statusCode int
+		readable   bool
+	}{
+		{"admin", "/admin/reservations/all/6", Repo.AdminShowReservation, 1, http.StatusOK, true},
+		{"not an admin", "/admin/reservations/all/6", Repo.AdminShowReservation, 3, http.StatusOK, false},
+		{"assigned counselor", "/account/sessions/6", Repo.CounselorShowSession, 2, http.StatusOK, true},
+		{"other counselor", "/account/sessions/6", Repo.CounselorShowSession, 3, http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", tt.userID)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "6")
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+		if readable := strings.Contains(rr.Body.String(), "Discussed family matters"); readable != tt.readable {
+			t.Errorf("%s: expected the note to be readable: %v", tt.name, tt.readable)
+		}
+	}
+}
+
+func TestRotateSessionNotes(t *testing.T) {
+	if rr := postSessionNote(2, "7", "Follow up next month"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("could not save a note, got %d", rr.Code)
+	}
+
+	oldKeyring := app.NotesKeyring
+	defer func() { app.NotesKeyring = oldKeyring }()
+
+	keyring, err := vault.New(map[int][]byte{
+		1: bytes.Repeat([]byte{1}, vault.KeySize),
+		2: bytes.Repeat([]byte{2}, vault.KeySize),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.NotesKeyring = keyring
+
+	//A note sealed with a key that was removed is skipped instead of stopping the rotation
+	lost, _ := Repo.DB.InsertSessionNote(models.SessionNote{ReservationID: 7, AuthorID: 2, KeyVersion: 9, Ciphertext: []byte("lost")})
+	app.NotesKeyring, _ = vault.New(map[int][]byte{1: bytes.Repeat([]byte{1}, vault.KeySize)})
+	if rr := postSessionNote(2, "7", "Client asked for shorter sessions"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("could not save a note, got %d", rr.Code)
+	}
+	app.NotesKeyring = keyring
+
+	rotated, err := Repo.RotateSessionNotes()
+	if err != nil || rotated == 0 {
+		t.Fatalf("expected notes to be re-encrypted, got %d, %v", rotated, err)
+	}
+
+	left, _ := Repo.DB.SessionNotesToRotate(2, 0, 100)
+	if len(left) != 1 || left[0].ID != lost {
+		t.Errorf("expected every note but the lost one on key 2, got %+v", left)
+	}
+
+	//The old key is no longer needed to read the notes, and the lost one is shown as unreadable
+	app.NotesKeyring, _ = vault.New(map[int][]byte{2: bytes.Repeat([]byte{2}, vault.KeySize)})
+	req := httptest.NewRequest("GET", "/admin/reservations/all/7", nil)
+	notes, err := Repo.openSessionNotes(req.WithContext(getCtx(req)), 7)
+	if err != nil || len(notes) != 3 {
+		t.Fatalf("expected three notes, got %+v, %v", notes, err)
+	}
+
+	bodies := []string{"Follow up next month", unreadableNoteBody, "Client asked for shorter sessions"}
+	for i, n := range notes {
+		if n.Body != bodies[i] {
+			t.Errorf("note %d: expected %q but got %q", i, bodies[i], n.Body)
+		}
+	}
+}
+
+func TestAdminPostReservationCounselor(t *testing.T) {
+	var tests = []struct {
+		name       string
+		id         string
+		counselor  string
+		statusCode int
+		flash      string
+		message    string
+	}{
+		{"free counselor", "8", "1", http.StatusSeeOther, "flash", "Reservation given to Session1"},
+		{"same counselor", "1", "1", http.StatusSeeOther, "error", "Choose another counselor"},
+		{"counselor not free", "8", "2", http.StatusSeeOther, "error", "Session1 is not free at this time"},
+		{"unknown counselor", "8", "200", http.StatusSeeOther, "error", "Choose another counselor"},
+		{"completed reservation", "2", "1", http.StatusSeeOther, "error", "A completed reservation can not be given to another counselor"},
+		{"unknown reservation", "1000", "1", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		testMailer.Reset()
+
+		values := url.Values{"counseling-session-id": {tt.counselor}}
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+tt.id+"/counselor", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "userId", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", tt.id)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostReservationCounselor).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+
+		if tt.flash != "" {
+			if got := session.PopString(req.Context(), tt.flash); got != tt.message {
+				t.Errorf("%s: expected %s %q but got %q", tt.name, tt.flash, tt.message, got)
+			}
+		}
+
+		if tt.flash != "flash" {
+			continue
+		}
+
+		//The client gets an updated invite and both counselors are told
+		sent := waitForMail(3)
+		subjects := make(map[string]bool)
+		for _, msg := range sent {
+			subjects[msg.Subject] = true
+			if msg.Subject == counselorChangedMail.Subject && (msg.To != "maryam@example.com" || len(msg.Attachments) != 1) {
+				t.Errorf("%s: expected an invite for the client, got %+v", tt.name, msg)
+			}
+		}
+		for _, subject := range []string{counselorChangedMail.Subject, "You have been given a counseling session",
+			"Your counseling session was given to another counselor"} {
+			if !subjects[subject] {
+				t.Errorf("%s: expected an email %q", tt.name, subject)
+			}
+		}
+	}
+}
+
+func TestCounselorSuggestionsLocalTime(t *testing.T) {
+	//Reservation times are scanned as UTC holding the local clock time, so a server east of UTC
+	//must still look for counselors free at 14:00
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	defer func() { time.Local = local }()
+
+	res, _ := Repo.DB.GetReservationByID(8)
+
+	suggestions, err := Repo.counselorSuggestions(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Slot.StartTime.Hour() != 14 {
+		t.Errorf("expected a counselor free at 14:00, got %+v", suggestions)
+	}
+}
+
+func TestCounselingSessionMailInvite(t *testing.T) {
+	res, _ := Repo.DB.GetReservationByID(9)
+
+	var sequences []string
+	for i := 0; i < 2; i++ {
+		msg, err := Repo.counselingSessionMail(res, models.ReservationConfirmed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Attachments) != 1 {
+			t.Fatalf("expected a calendar invite, got %d attachments", len(msg.Attachments))
+		}
+
+		invite := strings.ReplaceAll(string(msg.Attachments[0].Data), "\r\n ", "")
+		if !strings.Contains(invite, "mailto:maryam@example.com") || !strings.Contains(invite, "mailto:admin@example.com") {
+			t.Errorf("expected the client and the counselor as attendees, got %s", invite)
+		}
+
+		for _, line := range strings.Split(invite, "\r\n") {
+			if strings.HasPrefix(line, "SEQUENCE:") {
+				sequences = append(sequences, line)
+			}
+		}
+	}
+
+	if len(sequences) != 2 || sequences[0] != "SEQUENCE:1" || sequences[1] != "SEQUENCE:2" {
+		t.Errorf("expected the sequence to go up with each invite, got %v", sequences)
+	}
+}
+
+func TestManageBooking(t *testing.T) {
+	link := func(id int) url.Values {
+		u, _ := url.Parse(Repo.manageBookingLink(id))
+		return u.Query()
+	}
+
+	//The first weekday at least four days away, which is past the booking cutoff
+	day := time.Now().AddDate(0, 0, 4)
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, 1)
+	}
+	slot := func(hour int) string {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, time.Local).Format(time.RFC3339)
+	}
+
+	var tests = []struct {
+		name     string
+		handler  http.HandlerFunc
+		post     bool
+		values   url.Values
+		start    string
+		location string
+		page     string
+		mails    int
+	}{
+		{"show changeable booking", Repo.ManageBooking, false, link(3), "", "", "Move Your Session", 0},
+		{"show past booking", Repo.ManageBooking, false, link(1), "", "", "can no longer be changed online", 0},
+		{"forged link", Repo.ManageBooking, false, url.Values{"id": {"3"}, "sig": {"forged"}}, "", "/", "", 0},
+		{"cancel", Repo.PostManageBookingCancel, true, link(3), "", Repo.manageBookingLink(3), "", 2},
+		{"cancel past booking", Repo.PostManageBookingCancel, true, link(1), "", Repo.manageBookingLink(1), "", 0},
+		{"reschedule", Repo.PostManageBookingReschedule, true, link(3), slot(9), Repo.manageBookingLink(3), "", 2},
+		{"reschedule to a busy slot", Repo.PostManageBookingReschedule, true, link(3), slot(12), Repo.manageBookingLink(3), "", 0},
+		{"cancel when the email fails", Repo.PostManageBookingCancel, true, link(100), "", Repo.manageBookingLink(100), "", 0},
+	}
+
+	for _, tt := range tests {
+		testMailer.Reset()
+
+		if tt.start != "" {
+			tt.values.Set("start", tt.start)
+		}
+
+		method, body, target := "GET", "", "/bookings/manage?"+tt.values.Encode()
+		if tt.post {
+			method, body, target = "POST", tt.values.Encode(), "/bookings/manage"
+		}
+
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expected to be sent to %q but got %q", tt.name, tt.location, rr.Header().Get("Location"))
+		}
+
+		if tt.values.Get("id") == "100" && session.GetString(ctx, "warning") == "" {
+			t.Errorf("%s: expected a warning that the email was not sent", tt.name)
+		}
+
+		if !strings.Contains(rr.Body.String(), tt.page) {
+			t.Errorf("%s: expected the page to say %q", tt.name, tt.page)
+		}
+
+		if tt.mails == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		sent := waitForMail(tt.mails)
+		if len(sent) != tt.mails {
+			t.Errorf("%s: expected %d emails but got %d", tt.name, tt.mails, len(sent))
+		}
+	}
+}
+
+func TestSendDueReminders(t *testing.T) {
+	testMailer.Reset()
+
+	err := Repo.SendDueReminders([]time.Duration{24 * time.Hour, time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := waitForMail(2)
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 reminders but got %d", len(sent))
+	}
+
+	karachi, err := time.LoadLocation("Asia/Karachi")
+	if err != nil {
+		t.Skip("timezone data is not installed")
+	}
+	start := time.Date(2021, 5, 3, 14, 0, 0, 0, time.Local).In(karachi).Format("15:04")
+
+	for i, offset := range []string{"24 hours", "1 hour"} {
+		var msg models.MailData
+		for _, m := range sent {
+			if strings.HasSuffix(m.Subject, " "+offset) {
+				msg = m
+			}
+		}
+
+		if msg.To != "maryam@example.com" {
+			t.Errorf("reminder %d: expected a reminder %s before the session, got %+v", i, offset, sent)
+			continue
+		}
+
+		if !strings.Contains(msg.TextContent, start) || !strings.Contains(msg.TextContent, "Asia/Karachi") {
+			t.Errorf("reminder %d: expected the session time %s in the client's timezone, got %q", i, start, msg.TextContent)
+		}
+	}
+}
+
+func TestDurationText(t *testing.T) {
+	var tests = []struct {
+		d        time.Duration
+		expected string
+	}{
+		{24 * time.Hour, "24 hours"},
+		{time.Hour, "1 hour"},
+		{90 * time.Minute, "90 minutes"},
+		{time.Minute, "1 minute"},
+	}
+
+	for _, tt := range tests {
+		if got := durationText(tt.d); got != tt.expected {
+			t.Errorf("%s: expected %q but got %q", tt.d, tt.expected, got)
+		}
+	}
+}
+
+//nextMonday returns midnight of a Monday at least a week from now
+func nextMonday() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()+7+(int(time.Monday)-int(now.Weekday())+7)%7, 0, 0, 0, 0, time.Local)
+}
+
+func TestPostCounselingReservation(t *testing.T) {
+	monday := nextMonday()
+	slot := func(hour int) string {
+		return monday.Add(time.Duration(hour) * time.Hour).Format(time.RFC3339)
+	}
+	past := time.Date(2021, 5, 3, 9, 0, 0, 0, time.Local).Format(time.RFC3339)
+
+	var tests = []struct {
+		name       string
+		start      string
+		email      string
+		sameGender string
+		statusCode int
+		message    string
+	}{
+		{"free slot", slot(9), "john@example.com", "no", http.StatusSeeOther, ""},
+		{"first available", firstAvailableStart, "john@example.com", "no", http.StatusSeeOther, ""},
+		{"no slot picked", "", "john@example.com", "no", http.StatusOK, "Choose one of the available times"},
+		{"busy slot", slot(12), "john@example.com", "no", http.StatusOK, slotTakenMessage},
+		{"slot just taken", slot(16), "john@example.com", "no", http.StatusOK, slotTakenMessage},
+		{"slot in the past", past, "john@example.com", "no", http.StatusOK, pastStartMessage},
+		{"no counselor of the same gender", slot(9), "john@example.com", "yes", http.StatusOK, noMatchMessage},
+		{"invalid email", slot(9), "john", "no", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		testMailer.Reset()
+
+		values := url.Values{
+			"first-name":         {"John"},
+			"last-name":          {"Smith"},
+			"email":              {tt.email},
+			"gender":             {"male"},
+			"start":              {tt.start},
+			"intake-topic":       {"Family"},
+			"intake-urgency":     {"Not urgent"},
+			"intake-language":    {"English"},
+			"intake-same_gender": {tt.sameGender},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-session-reservation", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostCounselingReservation).ServeHTTP(rr, req)
+
+		if rr.Code != tt.statusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.statusCode, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), tt.message) {
+			t.Errorf("%s: expected the page to say %q", tt.name, tt.message)
+		}
+
+		if tt.statusCode != http.StatusSeeOther {
+			continue
+		}
+
+		res, ok := session.Get(req.Context(), "reservation").(models.Reservation)
+		if !ok || res.CounselingSession.CounselorName != "Session1" || res.Answer(models.IntakeTopic) != "Family" {
+			t.Errorf("%s: expected the booked reservation in the session, got %+v", tt.name, res)
+		}
+
+		booked := res.StartTime.Equal(monday.Add(9 * time.Hour))
+		if tt.start == firstAvailableStart {
+			booked = res.StartTime.After(time.Now())
+		}
+		if !booked {
+			t.Errorf("%s: expected the session at the picked time, got %v", tt.name, res.StartTime)
+		}
+
+		sent := waitForMail(1)
+		if len(sent) != 1 || sent[0].To != tt.email {
+			t.Errorf("%s: expected a counseling request email to %s, got %+v", tt.name, tt.email, sent)
+		}
+	}
+}
+
+func TestAvailabilityJSON(t *testing.T) {
+	routes := GetRoutes()
+	ts := httptest.NewTLSServer(routes)
+
+	defer ts.Close()
+
+	monday := nextMonday().Format("2006-01-02")
+	saturday := nextMonday().AddDate(0, 0, 5).Format("2006-01-02")
+
+	exceptions, _ := Repo.DB.CounselorScheduleExceptions(1)
+	eid := exceptions[0].StartDate.Format("2006-01-02")
+
+	var tests = []struct {
+		name       string
+		date       string
+		startTime  string
+		endTime    string
+		statusCode int
+		ok         bool
+		slots      int
+	}{
+		{"free morning", monday, "09:00", "12:00", http.StatusOK, true, 3},
+		{"around the busy hour", monday, "11:00", "14:00", http.StatusOK, true, 2},
+		{"busy hour only", monday, "12:00", "13:00", http.StatusOK, false, 0},
+		{"before working hours", monday, "06:00", "09:00", http.StatusOK, false, 0},
+		{"weekend", saturday, "09:00", "17:00", http.StatusOK, false, 0},
+		{"closed for eid", eid, "09:00", "17:00", http.StatusOK, false, 0},
+		{"in the past", "2021-05-03", "09:00", "17:00", http.StatusBadRequest, false, 0},
+		{"end before start", monday, "14:00", "09:00", http.StatusBadRequest, false, 0},
+		{"missing time", monday, "", "10:00", http.StatusBadRequest, false, 0},
+	}
+
+	for _, tt := range tests {
+		values := url.Values{}
+		values.Add("date", tt.date)
+		values.Add("start-time", tt.startTime)
+		values.Add("end-time", tt.endTime)
+
+		resp, err := ts.Client().PostForm(ts.URL+"/search-availability-json", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var j jsonResponse
+		err = json.NewDecoder(resp.Body).Decode(&j)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if resp.StatusCode != tt.statusCode || j.OK != tt.ok || len(j.Slots) != tt.slots {
+			t.Errorf("%s: expected %d, ok %v and %d slots but got %d, ok %v and %d slots",
+				tt.name, tt.statusCode, tt.ok, tt.slots, resp.StatusCode, j.OK, len(j.Slots))
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"html/template"
@@ -9,8 +11,13 @@ import (
 	"os"
 	"path/filepath"
 	"server/everydaymuslimappserver/internal/config"
+	"server/everydaymuslimappserver/internal/helpers"
+	"server/everydaymuslimappserver/internal/mailer"
 	"server/everydaymuslimappserver/internal/models"
+	"server/everydaymuslimappserver/internal/outbox"
 	"server/everydaymuslimappserver/internal/render"
+	"server/everydaymuslimappserver/internal/vault"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,13 +30,21 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":    render.HumanDate,
+	"dateWithTime": render.DateWithTime,
+	"clock":        render.Clock,
+	"join":         strings.Join,
+	"statusLabel":  models.ReservationStatusLabel,
+}
 
 const pathToTemplates = "./../../templates"
 
 func TestMain(m *testing.M) {
 	//put into the session
 	gob.Register(models.User{})
+	gob.Register(models.Signup{})
+	gob.Register(models.Reservation{})
 	//Change to true when in production
 	app.InProduction = false
 
@@ -47,14 +62,6 @@ func TestMain(m *testing.M) {
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction //True in Production
 
-	//test mail chan without sending mail
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	defer close(mailChan)
-
-	listenForMail()
-
 	app.Session = session
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -65,21 +72,54 @@ func TestMain(m *testing.M) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
+	app.SigningKey = []byte("test signing key")
+	app.NotesKeyring, err = vault.New(map[int][]byte{1: bytes.Repeat([]byte{1}, vault.KeySize)})
+	if err != nil {
+		log.Fatal("Can not create notes keyring", err)
+	}
+	app.EmailTemplatePath = "./../../email-templates"
+	app.Mailer = testMailer
+	app.SessionLength = time.Hour
+	app.BookingCutoff = 24 * time.Hour
+	app.EmailTemplateCache, err = render.CreateEmailTemplateCache(app.EmailTemplatePath)
+	if err != nil {
+		log.Fatal("Can not create email template cache", err)
+	}
+	helpers.NewHelpers(&app)
+
 	repo := NewTestRepo(&app)
 
 	NewHandlers(repo)
 
+	//send queued emails to testMailer instead of a mail server
+	listenForMail()
+
 	render.NewRenderer(&app)
 
 	os.Exit(m.Run())
 }
 
+//testMailer records the emails sent by handlers
+var testMailer = mailer.NewMemoryMailer()
+
 func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
+	pool := &outbox.Pool{
+		Store:        Repo.DB,
+		Mailer:       testMailer,
+		PollInterval: 10 * time.Millisecond,
+		InfoLog:      app.InfoLog,
+		ErrorLog:     app.ErrorLog,
+	}
+	go pool.Run(context.Background())
+}
+
+//waitForMail waits for the handlers to send n emails and returns what was sent
+func waitForMail(n int) []models.MailData {
+	deadline := time.Now().Add(time.Second)
+	for len(testMailer.Messages()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return testMailer.Messages()
 }
 
 func GetRoutes() http.Handler {
@@ -111,6 +151,48 @@ func GetRoutes() http.Handler {
 	mux.Get("/surahs", surahHandler.GetSurahs)
 	mux.Get("/surahs/{id}", surahHandler.GetSurahs)
 
+	mux.Get("/signup", Repo.NewsLetterSignup)
+	mux.Post("/signup", Repo.PostNewsLetterSignUp)
+	mux.Get("/signup-success", Repo.SignupSuccess)
+	mux.Get("/newsletter/confirm", Repo.ConfirmNewsletter)
+	mux.Get("/newsletter/unsubscribe", Repo.NewsletterUnsubscribe)
+	mux.Post("/newsletter/unsubscribe", Repo.PostNewsletterUnsubscribe)
+
+	mux.Get("/admin/privacy", Repo.AdminPrivacy)
+	mux.Get("/admin/audit", Repo.AdminAudit)
+	mux.Get("/admin/subscribers", Repo.AdminSubscribers)
+	mux.Get("/admin/subscribers/export", Repo.AdminSubscribersExport)
+	mux.Get("/admin/campaigns", Repo.AdminCampaigns)
+	mux.Get("/admin/campaigns/new", Repo.AdminNewCampaign)
+	mux.Get("/admin/campaigns/{id}", Repo.AdminShowCampaign)
+	mux.Get("/admin/campaigns/{id}/preview", Repo.AdminPreviewCampaign)
+	mux.Get("/admin/audit/export", Repo.AdminAuditExport)
+	mux.Get("/admin/outbox", Repo.AdminOutbox)
+	mux.Get("/admin/outbox/{id}/retry", Repo.AdminRetryOutboxEmail)
+	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Get("/counseling-reservation", Repo.CounselingSessionRegistration)
+	mux.Post("/make-session-reservation", Repo.PostCounselingReservation)
+	mux.Get("/counseling-reservation-success", Repo.CounselingReservationSuccess)
+	mux.Get("/bookings/manage", Repo.ManageBooking)
+	mux.Get("/admin/reservations/export", Repo.AdminReservationsExport)
+	mux.Get("/admin/reservations/report", Repo.AdminReservationsReport)
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Get("/admin/calendar", Repo.AdminReservationsCalendar)
+	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/api/dashboard", Repo.AdminDashboardJSON)
+	mux.Get("/admin/all-reservations", Repo.AdminAllReservations)
+	mux.Get("/admin/new-reservations", Repo.AdminNewReservations)
+	mux.Get("/admin/api/reservations", Repo.AdminReservationsJSON)
+	mux.Post("/admin/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+	mux.Get("/admin/email-templates", Repo.AdminEmailTemplates)
+	mux.Get("/admin/email-templates/{name}", Repo.AdminPreviewEmailTemplate)
+	mux.Get("/admin/counselors", Repo.AdminCounselors)
+	mux.Get("/admin/counselors/new", Repo.AdminNewCounselor)
+	mux.Get("/admin/counselors/{id}", Repo.AdminShowCounselor)
+	mux.Get("/admin/intake", Repo.AdminIntakeQuestions)
+	mux.Get("/admin/intake/new", Repo.AdminNewIntakeQuestion)
+	mux.Get("/admin/intake/{id}", Repo.AdminShowIntakeQuestion)
+
 	mux.Get("/*", Repo.DoesNotExistPage)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -1,9 +1,15 @@
 package helpers
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
 	"server/everydaymuslimappserver/internal/config"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,3 +31,75 @@ func HashPassword(password string) {
 
 	fmt.Println(string(hashedPassword))
 }
+
+//ClientIP returns the IP address of the client. X-Forwarded-For is only believed when the request
+//comes from one of the trusted proxies, and then the last address no trusted proxy added is used
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+
+	return host
+}
+
+//trustedProxy reports whether ip is one of the configured trusted proxies
+func trustedProxy(ip string) bool {
+	for _, proxy := range app.TrustedProxies {
+		if proxy == ip {
+			return true
+		}
+	}
+	return false
+}
+
+//RandomToken returns a random hex string that is safe to use in links
+func RandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+//Sign returns an HMAC signature of value made with the app signing key
+func Sign(value string) string {
+	mac := hmac.New(sha256.New, app.SigningKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//ValidSignature reports whether signature was made by Sign for value
+func ValidSignature(value, signature string) bool {
+	return hmac.Equal([]byte(Sign(value)), []byte(signature))
+}
+
+//CSVRecord returns the cells of a CSV row with a ' put before any cell starting with =, +, -, @,
+//a tab or a carriage return, so spreadsheet programs show it as text instead of running it as a formula
+func CSVRecord(cells ...string) []string {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+		record[i] = cell
+	}
+	return record
+}
@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"net/http/httptest"
+	"server/everydaymuslimappserver/internal/config"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	NewHelpers(&config.AppConfig{SigningKey: []byte("secret")})
+
+	sig := Sign("unsubscribe:1")
+
+	if !ValidSignature("unsubscribe:1", sig) {
+		t.Error("signature should be valid for the signed value")
+	}
+
+	if ValidSignature("unsubscribe:2", sig) {
+		t.Error("signature should not be valid for a different value")
+	}
+
+	NewHelpers(&config.AppConfig{SigningKey: []byte("another secret")})
+
+	if ValidSignature("unsubscribe:1", sig) {
+		t.Error("signature should not be valid with a different key")
+	}
+}
+
+func TestRandomToken(t *testing.T) {
+	a, err := RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := RandomToken()
+
+	if len(a) != 64 || a == b {
+		t.Error("expected two different 64 character tokens")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	NewHelpers(&config.AppConfig{TrustedProxies: []string{"10.0.0.1", "10.0.0.2"}})
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"direct", "203.0.113.5:5000", "", "203.0.113.5"},
+		{"forged header", "203.0.113.5:5000", "198.51.100.1", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"forged hop before the proxy", "10.0.0.1:5000", "192.0.2.9, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:5000", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.1:5000", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if got := ClientIP(r); got != tt.expected {
+			t.Errorf("%s: expected %s but got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestCSVRecord(t *testing.T) {
+	got := CSVRecord("=HYPERLINK(\"http://example.com\")", "+1", "-2", "@SUM(A1)", "\t=1+1", "\r=1+1", "Maryam", "", "a=b", "a\tb")
+	expected := []string{"'=HYPERLINK(\"http://example.com\")", "'+1", "'-2", "'@SUM(A1)", "'\t=1+1", "'\r=1+1", "Maryam", "", "a=b", "a\tb"}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("cell %d: expected %q but got %q", i, expected[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Calendar methods from RFC 5546
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+//Person is an organizer or attendee of an event
+type Person struct {
+	Name  string
+	Email string
+}
+
+//Event is a single calendar event sent as an invite
+type Event struct {
+	UID         string
+	Sequence    int
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Organizer   Person
+	Attendees   []Person
+}
+
+const dateTimeFormat = "20060102T150405Z"
+
+//Invite returns an RFC 5545 calendar holding the event, for the given method
+func Invite(method string, e Event) []byte {
+	var b bytes.Buffer
+
+	write := func(line string) {
+		b.WriteString(fold(line))
+		b.WriteString("\r\n")
+	}
+
+	status := "CONFIRMED"
+	if method == MethodCancel {
+		status = "CANCELLED"
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:-//Daily Productive Muslim//Counseling//EN")
+	write("CALSCALE:GREGORIAN")
+	write("METHOD:" + method)
+	write("BEGIN:VEVENT")
+	write("UID:" + e.UID)
+	write(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	write("DTSTAMP:" + time.Now().UTC().Format(dateTimeFormat))
+	write("DTSTART:" + e.Start.UTC().Format(dateTimeFormat))
+	write("DTEND:" + e.End.UTC().Format(dateTimeFormat))
+	write("SUMMARY:" + escape(e.Summary))
+	if e.Description != "" {
+		write("DESCRIPTION:" + escape(e.Description))
+	}
+	if e.Location != "" {
+		write("LOCATION:" + escape(e.Location))
+	}
+	if e.URL != "" {
+		write("URL:" + e.URL)
+	}
+	write("STATUS:" + status)
+	write(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", quote(e.Organizer.Name), e.Organizer.Email))
+	for _, a := range e.Attendees {
+		write(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=FALSE:mailto:%s", quote(a.Name), a.Email))
+	}
+	write("END:VEVENT")
+	write("END:VCALENDAR")
+
+	return b.Bytes()
+}
+
+//escape escapes a TEXT value
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return s
+}
+
+//quote makes a parameter value safe, quoting it when it holds separators
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `"`, "'")
+	if strings.ContainsAny(s, ":;,") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+//fold splits a content line into lines of at most 75 octets, without breaking UTF-8 characters
+func fold(line string) string {
+	const limit = 75
+
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > limit {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
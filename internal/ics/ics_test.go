@@ -0,0 +1,47 @@
+package ics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = Event{
+	UID:         "reservation-1@example.com",
+	Start:       time.Date(2021, 5, 3, 14, 0, 0, 0, time.UTC),
+	End:         time.Date(2021, 5, 3, 15, 0, 0, 0, time.UTC),
+	Summary:     "Counseling session with Aisha, Sister",
+	Description: "Join the session at https://meet.example.com/abc; bring your questions",
+	URL:         "https://meet.example.com/abc",
+	Organizer:   Person{Name: "Daily Productive Muslim", Email: "team@example.com"},
+	Attendees:   []Person{{Name: "Maryam Yusuf", Email: "maryam@example.com"}},
+}
+
+func TestInvite(t *testing.T) {
+	cal := string(Invite(MethodRequest, testEvent))
+	unfolded := strings.ReplaceAll(cal, "\r\n ", "")
+
+	for _, line := range []string{
+		"METHOD:REQUEST",
+		"DTSTART:20210503T140000Z",
+		"DTEND:20210503T150000Z",
+		"SUMMARY:Counseling session with Aisha\\, Sister",
+		"STATUS:CONFIRMED",
+		"ATTENDEE;CN=Maryam Yusuf;ROLE=REQ-PARTICIPANT;RSVP=FALSE:mailto:maryam@example.com",
+	} {
+		if !strings.Contains(unfolded, line+"\r\n") {
+			t.Errorf("invite is missing %q", line)
+		}
+	}
+
+	for _, line := range strings.Split(cal, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+
+	cancel := string(Invite(MethodCancel, testEvent))
+	if !strings.Contains(cancel, "METHOD:CANCEL\r\n") || !strings.Contains(cancel, "STATUS:CANCELLED\r\n") {
+		t.Error("cancellation is missing METHOD:CANCEL or STATUS:CANCELLED")
+	}
+}
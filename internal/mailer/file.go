@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/everydaymuslimappserver/internal/models"
+	"sync/atomic"
+	"time"
+)
+
+//FileMailer writes emails into a maildir instead of sending them, for development
+type FileMailer struct {
+	dir   string
+	count uint64
+}
+
+//NewFileMailer creates a FileMailer writing to the maildir at dir, creating it when missing
+func NewFileMailer(dir string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileMailer{
+		dir: dir,
+	}, nil
+}
+
+//Send writes one email into the maildir. The file is written to tmp and moved to new
+//so mail readers never see half written messages
+func (f *FileMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&f.count, 1), host)
+
+	tmp := filepath.Join(f.dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, []byte(email.GetMessage()), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(f.dir, "new", name))
+}
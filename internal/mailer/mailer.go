@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"fmt"
+	"server/everydaymuslimappserver/internal/models"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+//Mailer sends emails
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+//Config holds the mail settings read at startup
+type Config struct {
+	Driver     string
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+	Dir        string
+}
+
+//New creates the Mailer chosen by the config driver: smtp, file or memory
+func New(c Config) (Mailer, error) {
+	switch c.Driver {
+	case "", "smtp":
+		return NewSMTPMailer(c)
+	case "file":
+		return NewFileMailer(c.Dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", c.Driver)
+	}
+}
+
+//buildMessage turns mail data into a message with an HTML body, a plain text alternative when there is one, and attachments
+func buildMessage(m models.MailData) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+
+	for header, value := range m.Headers {
+		email.AddHeader(header, value)
+	}
+
+	email.SetBody(mail.TextHTML, m.Content)
+
+	if m.TextContent != "" {
+		email.AddAlternative(mail.TextPlain, m.TextContent)
+	}
+
+	for _, a := range m.Attachments {
+		email.AddAttachmentData(a.Data, a.Name, a.ContentType)
+	}
+
+	if err := email.GetError(); err != nil {
+		return nil, err
+	}
+
+	return email, nil
+}
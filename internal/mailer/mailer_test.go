@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"server/everydaymuslimappserver/internal/models"
+	"strings"
+	"testing"
+)
+
+var testMail = models.MailData{
+	To:          "you@there.com",
+	From:        "me@here.com",
+	Subject:     "Assalamu alaikum",
+	Content:     "<strong>Hello</strong>",
+	TextContent: "Hello",
+	Headers:     map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+	Attachments: []models.Attachment{{Name: "invite.ics", ContentType: "text/calendar; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")}},
+}
+
+func TestNew(t *testing.T) {
+	for _, driver := range []string{"", "smtp", "memory"} {
+		if _, err := New(Config{Driver: driver}); err != nil {
+			t.Errorf("driver %q: %s", driver, err)
+		}
+	}
+
+	if _, err := New(Config{Driver: "pigeon"}); err == nil {
+		t.Error("expected an error for an unknown driver")
+	}
+
+	if _, err := New(Config{Encryption: "rot13"}); err == nil {
+		t.Error("expected an error for an unknown SMTP encryption")
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mm := NewMemoryMailer()
+
+	_ = mm.Send(testMail)
+
+	messages := mm.Messages()
+	if len(messages) != 1 || messages[0].Subject != testMail.Subject {
+		t.Fatal("memory mailer did not record the email")
+	}
+
+	mm.Reset()
+	if len(mm.Messages()) != 0 {
+		t.Error("memory mailer still has emails after Reset")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fm, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fm.Send(testMail); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 email in the maildir but got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	msg := string(data)
+
+	if !strings.Contains(msg, "List-Unsubscribe: <https://example.com/unsubscribe>") {
+		t.Error("email is missing its headers")
+	}
+
+	if !strings.Contains(msg, "multipart/alternative") || !strings.Contains(msg, "Content-Type: text/plain") {
+		t.Error("email is missing its plain text part")
+	}
+
+	if !strings.Contains(msg, `filename="invite.ics"`) {
+		t.Error("email is missing its attachment")
+	}
+}
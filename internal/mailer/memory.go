@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"server/everydaymuslimappserver/internal/models"
+	"sync"
+)
+
+//MemoryMailer records emails instead of sending them, so tests can check what was sent
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+//NewMemoryMailer creates an empty MemoryMailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+//Send records one email
+func (mm *MemoryMailer) Send(m models.MailData) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.messages = append(mm.messages, m)
+	return nil
+}
+
+//Messages returns a copy of the recorded emails
+func (mm *MemoryMailer) Messages() []models.MailData {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	messages := make([]models.MailData, len(mm.messages))
+	copy(messages, mm.messages)
+	return messages
+}
+
+//Reset forgets the recorded emails
+func (mm *MemoryMailer) Reset() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.messages = nil
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"fmt"
+	"server/everydaymuslimappserver/internal/models"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+//SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	server *mail.SMTPServer
+}
+
+//NewSMTPMailer creates an SMTPMailer. Encryption is one of none, ssl or starttls
+func NewSMTPMailer(c Config) (*SMTPMailer, error) {
+	server := mail.NewSMTPClient()
+
+	server.Host = c.Host
+	server.Port = c.Port
+	server.Username = c.Username
+	server.Password = c.Password
+	server.KeepAlive = false
+
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	switch c.Encryption {
+	case "", "none":
+		server.Encryption = mail.EncryptionNone
+	case "ssl", "tls":
+		server.Encryption = mail.EncryptionSSLTLS
+	case "starttls":
+		server.Encryption = mail.EncryptionSTARTTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q", c.Encryption)
+	}
+
+	return &SMTPMailer{
+		server: server,
+	}, nil
+}
+
+//Send connects to the SMTP server and sends one email
+func (s *SMTPMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
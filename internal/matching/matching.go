@@ -0,0 +1,133 @@
+package matching
+
+import (
+	"fmt"
+	"server/everydaymuslimappserver/internal/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Points given for each part of a match
+const (
+	languagePoints  = 30
+	specialtyPoints = 20
+	workloadPenalty = 5
+	urgentDayCost   = 10
+	dayCost         = 2
+)
+
+//urgentAnswer is the urgency answer that makes an early session count for more
+const urgentAnswer = "As soon as possible"
+
+//Request is what a client asked for in their counseling request
+type Request struct {
+	Gender     string
+	SameGender bool
+	Language   string
+	Topic      string
+	Urgent     bool
+}
+
+//NewRequest reads the matching preferences from a reservation's gender and intake answers
+func NewRequest(res models.Reservation) Request {
+	return Request{
+		Gender:     res.Gender,
+		SameGender: res.Answer(models.IntakeSameGender) == "yes",
+		Language:   res.Answer(models.IntakeLanguage),
+		Topic:      res.Answer(models.IntakeTopic),
+		Urgent:     res.Answer(models.IntakeUrgency) == urgentAnswer,
+	}
+}
+
+//Candidate is a counselor who is free to take a request, with their earliest free slot and how many
+//upcoming sessions they already have
+type Candidate struct {
+	Counselor models.CounselingSession
+	Slot      models.Slot
+	Workload  int
+}
+
+//Match is a candidate scored against a request. Reasons explain the score to admins
+type Match struct {
+	Candidate
+	Score   int
+	Reasons []string
+}
+
+//Rank scores the candidates for a request, best first. Counselors of another gender are left out
+//when the client asked for the same gender
+func Rank(req Request, candidates []Candidate) []Match {
+	var matches []Match
+
+	var earliest time.Time
+	for _, c := range candidates {
+		if earliest.IsZero() || c.Slot.StartTime.Before(earliest) {
+			earliest = c.Slot.StartTime
+		}
+	}
+
+	for _, c := range candidates {
+		m := Match{Candidate: c}
+
+		if req.SameGender {
+			if req.Gender == "" || c.Counselor.Gender != req.Gender {
+				continue
+			}
+			m.Reasons = append(m.Reasons, "same gender")
+		}
+
+		if req.Language != "" && contains(c.Counselor.Languages, req.Language) {
+			m.Score += languagePoints
+			m.Reasons = append(m.Reasons, "speaks "+req.Language)
+		}
+
+		if req.Topic != "" && contains(c.Counselor.Specialties, req.Topic) {
+			m.Score += specialtyPoints
+			m.Reasons = append(m.Reasons, "specializes in "+strings.ToLower(req.Topic))
+		}
+
+		m.Score -= c.Workload * workloadPenalty
+		m.Reasons = append(m.Reasons, fmt.Sprintf("%d upcoming sessions", c.Workload))
+
+		cost := dayCost
+		if req.Urgent {
+			cost = urgentDayCost
+		}
+		days := int(c.Slot.StartTime.Sub(earliest).Hours() / 24)
+		m.Score -= days * cost
+		if days > 0 {
+			m.Reasons = append(m.Reasons, fmt.Sprintf("free %d days later", days))
+		} else {
+			m.Reasons = append(m.Reasons, "free earliest")
+		}
+
+		matches = append(matches, m)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Slot.StartTime.Equal(b.Slot.StartTime) {
+			return a.Slot.StartTime.Before(b.Slot.StartTime)
+		}
+		if a.Workload != b.Workload {
+			return a.Workload < b.Workload
+		}
+		return a.Counselor.ID < b.Counselor.ID
+	})
+
+	return matches
+}
+
+//contains reports whether values has value, ignoring case
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package matching
+
+import (
+	"server/everydaymuslimappserver/internal/models"
+	"testing"
+	"time"
+)
+
+var monday = time.Date(2021, 5, 3, 10, 0, 0, 0, time.UTC)
+
+func candidate(id int, gender string, languages, specialties []string, workload, days int) Candidate {
+	start := monday.AddDate(0, 0, days)
+	return Candidate{
+		Counselor: models.CounselingSession{
+			ID:          id,
+			Gender:      gender,
+			Languages:   languages,
+			Specialties: specialties,
+		},
+		Slot:     models.Slot{CounselingSessionID: id, StartTime: start, EndTime: start.Add(time.Hour)},
+		Workload: workload,
+	}
+}
+
+func TestRank(t *testing.T) {
+	aisha := candidate(1, "female", []string{"English", "Arabic"}, []string{"family"}, 2, 0)
+	bilal := candidate(2, "male", []string{"Urdu"}, []string{"youth"}, 0, 0)
+	sara := candidate(3, "female", []string{"urdu"}, []string{"grief"}, 0, 3)
+
+	var tests = []struct {
+		name  string
+		req   Request
+		order []int
+	}{
+		{"language and topic", Request{Language: "English", Topic: "Family"}, []int{1, 2, 3}},
+		{"no preferences", Request{}, []int{2, 3, 1}},
+		{"same gender leaves others out", Request{Gender: "female", SameGender: true, Language: "Urdu"}, []int{3, 1}},
+		{"same gender without a gender", Request{SameGender: true}, nil},
+		{"urgent prefers sooner", Request{Language: "Urdu", Urgent: true}, []int{2, 3, 1}},
+		{"not urgent can wait", Request{Gender: "female", Language: "Urdu", Topic: "Grief"}, []int{3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		matches := Rank(tt.req, []Candidate{aisha, bilal, sara})
+
+		var order []int
+		for _, m := range matches {
+			order = append(order, m.Counselor.ID)
+		}
+
+		if len(order) != len(tt.order) {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.order, order)
+			continue
+		}
+		for i := range order {
+			if order[i] != tt.order[i] {
+				t.Errorf("%s: expected %v but got %v", tt.name, tt.order, order)
+				break
+			}
+		}
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	res := models.Reservation{
+		Gender: "male",
+		Answers: []models.IntakeAnswer{
+			{QuestionKey: models.IntakeTopic, Answer: "Grief"},
+			{QuestionKey: models.IntakeUrgency, Answer: "As soon as possible"},
+			{QuestionKey: models.IntakeLanguage, Answer: "Urdu"},
+			{QuestionKey: models.IntakeSameGender, Answer: "yes"},
+		},
+	}
+
+	req := NewRequest(res)
+	want := Request{Gender: "male", SameGender: true, Language: "Urdu", Topic: "Grief", Urgent: true}
+	if req != want {
+		t.Errorf("expected %+v but got %+v", want, req)
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"html/template"
+	"time"
+)
 
 type User struct {
 	ID          int       `json:"id"`
@@ -20,6 +23,88 @@ type Signup struct {
 	Email     string
 }
 
+//Newsletter subscriber statuses
+const (
+	SubscriberPending      = "pending"
+	SubscriberConfirmed    = "confirmed"
+	SubscriberUnsubscribed = "unsubscribed"
+)
+
+//NewsletterSubscriber is the newsletter_subscribers DB model
+type NewsletterSubscriber struct {
+	ID             int       `json:"id"`
+	FirstName      string    `json:"firstName"`
+	LastName       string    `json:"lastName"`
+	Email          string    `json:"email"`
+	Status         string    `json:"status"`
+	Token          string    `json:"-"`
+	ConfirmedAt    time.Time `json:"confirmedAt"`
+	UnsubscribedAt time.Time `json:"unsubscribedAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Tags           []string  `json:"tags"`
+}
+
+//SubscriberTag is a segment of newsletter subscribers that campaigns can target
+type SubscriberTag struct {
+	ID          int
+	Name        string
+	Subscribers int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//SubscriberFilter narrows down the newsletter subscribers returned from the DB
+type SubscriberFilter struct {
+	Status string
+	TagID  int
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+//Newsletter campaign statuses
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignSending   = "sending"
+	CampaignSent      = "sent"
+	CampaignCancelled = "cancelled"
+)
+
+//Campaign recipient statuses
+const (
+	RecipientPending = "pending"
+	RecipientQueued  = "queued"
+	RecipientSkipped = "skipped"
+	RecipientFailed  = "failed"
+)
+
+//Campaign is the newsletter_campaigns DB model
+type Campaign struct {
+	ID          int
+	Subject     string
+	Body        string
+	Audience    string
+	Status      string
+	ScheduledAt time.Time
+	SentAt      time.Time
+	CreatedBy   int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//CampaignRecipient is the newsletter_campaign_recipients DB model
+type CampaignRecipient struct {
+	ID           int
+	CampaignID   int
+	SubscriberID int
+	Status       string
+	Error        string
+	QueuedAt     time.Time
+	Subscriber   NewsletterSubscriber
+}
+
 type UserRegistration struct {
 	FirstName string
 	LastName  string
@@ -34,11 +119,92 @@ type HijriDate struct {
 
 //MailData model
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	TextContent string
+	Template    string
+	Headers     map[string]string
+	Attachments []Attachment
+}
+
+//Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+//EmailData is the data every email template can use
+type EmailData struct {
+	FirstName       string
+	LastName        string
+	BaseURL         string
+	UnsubscribeLink string
+}
+
+//NewsletterConfirmEmail is the data for newsletter-confirm.email.html
+type NewsletterConfirmEmail struct {
+	EmailData
+	ConfirmLink string
+}
+
+//CounselingSessionEmail is the data for the counseling session confirmation and cancellation emails
+type CounselingSessionEmail struct {
+	EmailData
+	Counselor   string
+	Start       time.Time
+	End         time.Time
+	MeetingLink string
+	ManageLink  string
+}
+
+//SessionReminderEmail is the data for counseling-reminder.email.html. Start and End are in the client's timezone
+type SessionReminderEmail struct {
+	CounselingSessionEmail
+	StartsIn string
+	Timezone string
+}
+
+//BookingChangeEmail is the data for counselor-booking-changed.email.html, telling a counselor
+//that a client cancelled or moved their session. FirstName and LastName are the counselor's.
+//Change is "assigned" or "unassigned" when the session was given to or taken from the counselor
+type BookingChangeEmail struct {
+	EmailData
+	Client        string
+	Status        string
+	Change        string
+	Start         time.Time
+	End           time.Time
+	PreviousStart time.Time
+}
+
+//CampaignEmail is the data for campaign.email.html. Body is trusted HTML written by admins
+type CampaignEmail struct {
+	EmailData
+	Body template.HTML
+}
+
+//Outbox email statuses
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+//OutboxEmail is an email waiting in, or sent from, the email_outbox table
+type OutboxEmail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 //Reservation is the reservations Model
@@ -54,17 +220,191 @@ type Reservation struct {
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 	CounselingSession   CounselingSession
-	Processed           int
+	Status              string
+	MeetingLink         string
+	Timezone            string
+	Gender              string
+	Answers             []IntakeAnswer
+}
+
+//Answer returns the client's answer to the intake question with key, or "" if there is none
+func (r Reservation) Answer(key string) string {
+	for _, a := range r.Answers {
+		if a.QuestionKey == key {
+			return a.Answer
+		}
+	}
+	return ""
+}
+
+//Reservation statuses
+const (
+	ReservationRequested        = "requested"
+	ReservationConfirmed        = "confirmed"
+	ReservationRescheduled      = "rescheduled"
+	ReservationCompleted        = "completed"
+	ReservationCancelledByUser  = "cancelled_by_user"
+	ReservationCancelledByStaff = "cancelled_by_staff"
+	ReservationNoShow           = "no_show"
+)
+
+//ReservationStatuses lists every reservation status in the order a booking goes through them
+var ReservationStatuses = []string{
+	ReservationRequested,
+	ReservationConfirmed,
+	ReservationRescheduled,
+	ReservationCompleted,
+	ReservationCancelledByUser,
+	ReservationCancelledByStaff,
+	ReservationNoShow,
 }
 
-//CounselingSession struct has the data about counseling sessions
+//reservationTransitions lists the statuses each reservation status can move to.
+//Completed, cancelled and no show reservations are final
+var reservationTransitions = map[string][]string{
+	ReservationRequested: {ReservationConfirmed, ReservationRescheduled,
+		ReservationCancelledByUser, ReservationCancelledByStaff},
+	ReservationConfirmed: {ReservationRescheduled, ReservationCompleted,
+		ReservationCancelledByUser, ReservationCancelledByStaff, ReservationNoShow},
+	ReservationRescheduled: {ReservationConfirmed, ReservationRescheduled, ReservationCompleted,
+		ReservationCancelledByUser, ReservationCancelledByStaff, ReservationNoShow},
+}
+
+//reservationStatusLabels are how reservation statuses are shown to people
+var reservationStatusLabels = map[string]string{
+	ReservationRequested:        "Requested",
+	ReservationConfirmed:        "Confirmed",
+	ReservationRescheduled:      "Rescheduled",
+	ReservationCompleted:        "Completed",
+	ReservationCancelledByUser:  "Cancelled by client",
+	ReservationCancelledByStaff: "Cancelled by staff",
+	ReservationNoShow:           "No show",
+}
+
+//NextReservationStatuses returns the statuses a reservation in status can move to
+func NextReservationStatuses(status string) []string {
+	return reservationTransitions[status]
+}
+
+//CanTransitionReservation reports whether a reservation can move from one status to another
+func CanTransitionReservation(from, to string) bool {
+	for _, next := range reservationTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+//IsCancelledReservation reports whether status is one of the cancelled statuses
+func IsCancelledReservation(status string) bool {
+	return status == ReservationCancelledByUser || status == ReservationCancelledByStaff
+}
+
+//ReservationStatusLabel returns how a reservation status is shown to people
+func ReservationStatusLabel(status string) string {
+	if label, ok := reservationStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+//ReservationFilter narrows down, sorts and pages the reservations returned from the DB.
+//Sort is one of date, created, name, counselor or status. From and To compare with the session start
+type ReservationFilter struct {
+	Status              string
+	CounselingSessionID int
+	From                time.Time
+	To                  time.Time
+	Search              string
+	Sort                string
+	Desc                bool
+	Limit               int
+	Offset              int
+}
+
+//Reminder is a reminder email due before a reservation starts
+type Reminder struct {
+	Reservation Reservation
+	Offset      time.Duration
+}
+
+//ReservationStatusChange records a reservation moving from one status to another, and who moved it.
+//StartTime and EndTime are the reservation's times after the change
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	ChangedBy     int
+	ChangedByName string
+	StartTime     time.Time
+	EndTime       time.Time
+	CreatedAt     time.Time
+}
+
+//CounselingSession struct has the data about counseling sessions. Each row is one counselor,
+//optionally linked to the user account they log in with
 type CounselingSession struct {
 	ID            int
 	CounselorName string
+	UserID        int
+	UserEmail     string
+	Bio           string
+	Gender        string
+	Languages     []string
+	Specialties   []string
+	Active        bool
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
 
+//AvailabilityWindow is a recurring weekly time when a counselor takes sessions.
+//Times are minutes after midnight
+type AvailabilityWindow struct {
+	ID                  int
+	CounselingSessionID int
+	Weekday             time.Weekday
+	StartMinute         int
+	EndMinute           int
+}
+
+//ScheduleException replaces a counselor's weekly windows from StartDate to EndDate, such as a holiday
+//when Closed is true, or Ramadan hours from StartMinute to EndMinute
+type ScheduleException struct {
+	ID                  int
+	CounselingSessionID int
+	StartDate           time.Time
+	EndDate             time.Time
+	Closed              bool
+	StartMinute         int
+	EndMinute           int
+	Note                string
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+//Slot is a free time for a counseling session with one counselor
+type Slot struct {
+	CounselingSessionID int       `json:"counselingSessionId"`
+	CounselorName       string    `json:"counselorName"`
+	StartTime           time.Time `json:"startTime"`
+	EndTime             time.Time `json:"endTime"`
+}
+
+//CalendarEvent is a booking or owner block shown on the admin reservation calendar
+type CalendarEvent struct {
+	ID                  int       `json:"id"`
+	Kind                string    `json:"kind"`
+	Title               string    `json:"title"`
+	Status              string    `json:"status,omitempty"`
+	CounselingSessionID int       `json:"counselingSessionId"`
+	CounselorName       string    `json:"counselorName"`
+	StartTime           time.Time `json:"startTime"`
+	EndTime             time.Time `json:"endTime"`
+	URL                 string    `json:"url,omitempty"`
+}
+
 //Restriction is the room DB model
 type Restriction struct {
 	ID              int
@@ -73,19 +413,26 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+//The restriction ids seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+)
+
 //CounselingSessionRestriction is the couseling session time restriction DB model
 type CounselingSessionTimeRestriction struct {
-	ID            int
-	StartTime     time.Time
-	EndTime       time.Time
-	Date          time.Time
-	ReservationID int
-	RestrictionID int
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Session       CounselingSession
-	Reservation   Reservation
-	Restriction   Reservation
+	ID                  int
+	StartTime           time.Time
+	EndTime             time.Time
+	Date                time.Time
+	CounselingSessionID int
+	ReservationID       int
+	RestrictionID       int
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Session             CounselingSession
+	Reservation         Reservation
+	Restriction         Reservation
 }
 
 type CounselingRegistration struct {
@@ -94,5 +441,133 @@ type CounselingRegistration struct {
 	LastName  string `json:"lastName"`
 	Email     string `json:"email"`
 	Gender    string `json:"gender"`
-	Reason    string `json:"reason"`
+}
+
+//Intake question kinds
+const (
+	IntakeChoice = "choice"
+	IntakeText   = "text"
+	IntakeYesNo  = "yes_no"
+)
+
+//The keys of the intake questions used to match clients with counselors
+const (
+	IntakeTopic      = "topic"
+	IntakeUrgency    = "urgency"
+	IntakeLanguage   = "language"
+	IntakeSameGender = "same_gender"
+)
+
+//IntakeQuestion is a question admins ask everyone requesting a counseling session
+type IntakeQuestion struct {
+	ID        int
+	Key       string
+	Label     string
+	Kind      string
+	Options   []string
+	Required  bool
+	Position  int
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//IntakeAnswer is a client's answer to an intake question. The question's key and label are
+//copied so the answer still reads the same after the question is changed
+type IntakeAnswer struct {
+	ID            int    `json:"-"`
+	ReservationID int    `json:"-"`
+	QuestionID    int    `json:"-"`
+	QuestionKey   string `json:"question"`
+	QuestionLabel string `json:"label"`
+	Answer        string `json:"answer"`
+}
+
+//AccessLevelAdmin is the user access level of admins
+const AccessLevelAdmin = 3
+
+//SessionNote is a counselor's confidential note about a session. Only the ciphertext is stored,
+//Body is filled in when the note is opened
+type SessionNote struct {
+	ID            int
+	ReservationID int
+	AuthorID      int
+	AuthorName    string
+	KeyVersion    int
+	Ciphertext    []byte
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//PersonalData holds everything stored about one person, used for data exports
+type PersonalData struct {
+	ExportedAt   time.Time              `json:"exportedAt"`
+	Email        string                 `json:"email"`
+	Profile      *User                  `json:"profile,omitempty"`
+	Newsletter   []NewsletterSubscriber `json:"newsletterSubscriptions"`
+	Reservations []Reservation          `json:"reservations"`
+	Activity     []ActivityRecord       `json:"activity"`
+}
+
+//ActivityRecord is one thing a user did while logged in, as kept in the audit log
+type ActivityRecord struct {
+	Action    string    `json:"action"`
+	IPAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//AuditEvent is a record of an admin or auth action
+type AuditEvent struct {
+	ID         int
+	ActorID    int
+	ActorEmail string
+	Action     string
+	Target     string
+	Before     string
+	After      string
+	IPAddress  string
+	UserAgent  string
+	CreatedAt  time.Time
+}
+
+//AuditFilter narrows down the audit events returned from the DB
+type AuditFilter struct {
+	Action  string
+	Actor   string
+	ActorID int
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+//WeekCount is how many things happened in the week starting on Week
+type WeekCount struct {
+	Week  time.Time `json:"week"`
+	Count int       `json:"count"`
+}
+
+//ContentViews is how often one hadith, ayah, dua or surah was looked at
+type ContentViews struct {
+	ContentType string `json:"contentType"`
+	ContentID   string `json:"contentId"`
+	Views       int    `json:"views"`
+}
+
+//DashboardStats are the numbers on the admin dashboard. Rates are fractions of the sessions
+//due in the last RateDays days that weren't cancelled
+type DashboardStats struct {
+	NewReservationsThisWeek int            `json:"newReservationsThisWeek"`
+	NewReservationsLastWeek int            `json:"newReservationsLastWeek"`
+	SessionsDue             int            `json:"sessionsDue"`
+	CompletionRate          float64        `json:"completionRate"`
+	NoShowRate              float64        `json:"noShowRate"`
+	RateDays                int            `json:"rateDays"`
+	Subscribers             int            `json:"subscribers"`
+	Users                   int            `json:"users"`
+	ReservationsPerWeek     []WeekCount    `json:"reservationsPerWeek"`
+	SubscribersPerWeek      []WeekCount    `json:"subscribersPerWeek"`
+	RegistrationsPerWeek    []WeekCount    `json:"registrationsPerWeek"`
+	TopContent              []ContentViews `json:"topContent"`
 }
@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"os"
+	"server/everydaymuslimappserver/internal/mailer"
+	"server/everydaymuslimappserver/internal/models"
+	"sync"
+	"time"
+)
+
+//Store is the part of the repository the workers need to claim and update queued emails
+type Store interface {
+	ClaimOutboxEmails(limit int) ([]models.OutboxEmail, error)
+	MarkOutboxEmailSent(id int) error
+	MarkOutboxEmailFailed(id int, errorMessage string, nextAttempt time.Time, dead bool) error
+}
+
+const (
+	baseDelay = 30 * time.Second
+	maxDelay  = 6 * time.Hour
+)
+
+//Pool is a group of workers sending emails from the outbox
+type Pool struct {
+	Store        Store
+	Mailer       mailer.Mailer
+	Workers      int
+	MaxAttempts  int
+	PollInterval time.Duration
+	InfoLog      *log.Logger
+	ErrorLog     *log.Logger
+}
+
+//Backoff returns how long to wait before retrying an email that has failed attempt times
+func Backoff(attempt int) time.Duration {
+	d := baseDelay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= maxDelay {
+			return maxDelay
+		}
+	}
+	return d
+}
+
+//Run starts the workers and blocks until ctx is cancelled and every worker has finished its current email
+func (p *Pool) Run(ctx context.Context) {
+	if p.Workers <= 0 {
+		p.Workers = 1
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 8
+	}
+	if p.PollInterval <= 0 {
+		p.PollInterval = 5 * time.Second
+	}
+	if p.InfoLog == nil {
+		p.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	}
+	if p.ErrorLog == nil {
+		p.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+//work claims and sends one email at a time, waiting for the poll interval whenever the outbox is empty
+func (p *Pool) work(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		emails, err := p.Store.ClaimOutboxEmails(1)
+		if err != nil {
+			p.ErrorLog.Println("Error claiming outbox emails", err)
+		}
+
+		if len(emails) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.PollInterval):
+			}
+			continue
+		}
+
+		for _, e := range emails {
+			p.send(e)
+		}
+	}
+}
+
+//send sends one claimed email and records the outcome
+func (p *Pool) send(e models.OutboxEmail) {
+	err := p.Mailer.Send(e.Mail)
+	if err == nil {
+		if err = p.Store.MarkOutboxEmailSent(e.ID); err != nil {
+			p.ErrorLog.Println("Error marking outbox email", e.ID, "as sent", err)
+			return
+		}
+		p.InfoLog.Println("Email Sent to", e.Mail.To)
+		return
+	}
+
+	dead := e.Attempts >= p.MaxAttempts
+	if dead {
+		p.ErrorLog.Println("Giving up on email", e.ID, "to", e.Mail.To, "after", e.Attempts, "attempts:", err)
+	} else {
+		p.ErrorLog.Println("Error sending email", e.ID, "to", e.Mail.To, err)
+	}
+
+	err = p.Store.MarkOutboxEmailFailed(e.ID, err.Error(), time.Now().Add(Backoff(e.Attempts)), dead)
+	if err != nil {
+		p.ErrorLog.Println("Error marking outbox email", e.ID, "as failed", err)
+	}
+}
@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"server/everydaymuslimappserver/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failure struct {
+	id   int
+	dead bool
+}
+
+type fakeStore struct {
+	mu      sync.Mutex
+	pending []models.OutboxEmail
+	sent    []int
+	failed  []failure
+}
+
+func (s *fakeStore) ClaimOutboxEmails(limit int) ([]models.OutboxEmail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil, nil
+	}
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	e.Attempts++
+	return []models.OutboxEmail{e}, nil
+}
+
+func (s *fakeStore) MarkOutboxEmailSent(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxEmailFailed(id int, errorMessage string, nextAttempt time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed = append(s.failed, failure{id: id, dead: dead})
+	return nil
+}
+
+type fakeMailer struct{}
+
+func (fakeMailer) Send(m models.MailData) error {
+	if m.To == "broken@example.com" {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{5, 8 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.expected {
+			t.Errorf("Backoff(%d): expected %s but got %s", tt.attempt, tt.expected, got)
+		}
+	}
+}
+
+func TestPoolRun(t *testing.T) {
+	store := &fakeStore{pending: []models.OutboxEmail{
+		{ID: 1, Mail: models.MailData{To: "me@here.com"}},
+		{ID: 2, Mail: models.MailData{To: "broken@example.com"}},
+		{ID: 3, Mail: models.MailData{To: "broken@example.com"}, Attempts: 7},
+	}}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	pool := &Pool{
+		Store:        store,
+		Mailer:       fakeMailer{},
+		Workers:      2,
+		MaxAttempts:  8,
+		PollInterval: 10 * time.Millisecond,
+		InfoLog:      logger,
+		ErrorLog:     logger,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	pool.Run(ctx)
+
+	if len(store.sent) != 1 || store.sent[0] != 1 {
+		t.Errorf("expected email 1 to be sent, got %v", store.sent)
+	}
+
+	if len(store.failed) != 2 {
+		t.Fatalf("expected 2 failed emails, got %d", len(store.failed))
+	}
+
+	for _, f := range store.failed {
+		if f.id == 2 && f.dead {
+			t.Error("email 2 should be retried, not dead lettered")
+		}
+		if f.id == 3 && !f.dead {
+			t.Error("email 3 should be dead lettered after reaching the max attempts")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//CreateEmailTemplateCache parses every *.email.html in path together with the email layouts and partials
+func CreateEmailTemplateCache(path string) (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	pages, err := filepath.Glob(fmt.Sprintf("%s/*.email.html", path))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, page := range pages {
+		name := filepath.Base(page)
+
+		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return myCache, err
+		}
+
+		for _, shared := range []string{"*.layout.html", "*.partial.html"} {
+			matches, err := filepath.Glob(filepath.Join(path, shared))
+			if err != nil {
+				return myCache, err
+			}
+
+			if len(matches) > 0 {
+				ts, err = ts.ParseFiles(matches...)
+				if err != nil {
+					return myCache, err
+				}
+			}
+		}
+		myCache[name] = ts
+	}
+	return myCache, nil
+}
+
+//Email renders an email template with data, returning the HTML body and a plain text version of it
+func Email(tmpl string, data interface{}) (string, string, error) {
+	var tc map[string]*template.Template
+
+	if app.UseCache {
+		tc = app.EmailTemplateCache
+	} else {
+		var err error
+		tc, err = CreateEmailTemplateCache(app.EmailTemplatePath)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	t, ok := tc[tmpl]
+	if !ok {
+		return "", "", errors.New("Could not get email template " + tmpl + " from the cache")
+	}
+
+	htmlBuf := new(bytes.Buffer)
+	if err := t.Execute(htmlBuf, data); err != nil {
+		return "", "", err
+	}
+
+	//The text part only needs the message, not the layout around it
+	bodyBuf := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(bodyBuf, "body", data); err != nil {
+		return "", "", err
+	}
+
+	return htmlBuf.String(), HTMLToText(bodyBuf.String()), nil
+}
+
+var (
+	blockTags     = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	linkTags      = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	lineBreakTags = regexp.MustCompile(`(?i)<br\s*/?>|</li>|</tr>`)
+	paragraphTags = regexp.MustCompile(`(?i)</(p|div|h[1-6]|table|ul|ol)>`)
+	listItemTags  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	anyTag        = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaces        = regexp.MustCompile(`[ \t]+`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+//HTMLToText turns an HTML email body into readable plain text. Links keep their address in brackets
+func HTMLToText(s string) string {
+	s = blockTags.ReplaceAllString(s, "")
+	s = linkTags.ReplaceAllStringFunc(s, func(link string) string {
+		parts := linkTags.FindStringSubmatch(link)
+		href, text := parts[1], strings.TrimSpace(anyTag.ReplaceAllString(parts[2], ""))
+		if text == "" || text == href {
+			return href
+		}
+		return text + " (" + href + ")"
+	})
+	s = lineBreakTags.ReplaceAllString(s, "\n")
+	s = paragraphTags.ReplaceAllString(s, "\n\n")
+	s = listItemTags.ReplaceAllString(s, "- ")
+	s = anyTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+
+	s = strings.Join(lines, "\n")
+	s = blankLines.ReplaceAllString(s, "\n\n")
+
+	return strings.TrimSpace(s) + "\n"
+}
@@ -0,0 +1,50 @@
+package render
+
+import (
+	"server/everydaymuslimappserver/internal/config"
+	"server/everydaymuslimappserver/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	html := `<style>p { color: red; }</style>
+		<p><strong>Assalamu alaikum</strong></p>
+		<p>Dear Maryam,<br>please <a href="https://example.com/confirm">confirm</a> &amp; enjoy</p>
+		<ul><li>one</li><li>two</li></ul>`
+
+	expected := "Assalamu alaikum\n\nDear Maryam,\nplease confirm (https://example.com/confirm) & enjoy\n\n- one\n- two\n"
+
+	if got := HTMLToText(html); got != expected {
+		t.Errorf("expected %q but got %q", expected, got)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tc, err := CreateEmailTemplateCache("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app = &config.AppConfig{UseCache: true, EmailTemplateCache: tc}
+
+	htmlBody, text, err := Email("newsletter-confirm.email.html", models.NewsletterConfirmEmail{
+		EmailData:   models.EmailData{FirstName: "<script>", LastName: "Yusuf"},
+		ConfirmLink: "https://example.com/newsletter/confirm?token=abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(htmlBody, "<html") || strings.Contains(htmlBody, "<script>") {
+		t.Error("email was not rendered in its layout with escaped data")
+	}
+
+	if strings.Contains(text, "<html") || !strings.Contains(text, "Dear <script> Yusuf") {
+		t.Errorf("unexpected plain text part %q", text)
+	}
+
+	if _, _, err = Email("missing.email.html", nil); err == nil {
+		t.Error("expected an error for a missing email template")
+	}
+}
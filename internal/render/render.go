@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"server/everydaymuslimappserver/internal/config"
 	"server/everydaymuslimappserver/internal/models"
+	"strings"
 	"time"
 
 	"github.com/justinas/nosurf"
@@ -18,6 +19,9 @@ var app *config.AppConfig
 var functions = template.FuncMap{
 	"humanDate":    HumanDate,
 	"dateWithTime": DateWithTime,
+	"clock":        Clock,
+	"join":         strings.Join,
+	"statusLabel":  models.ReservationStatusLabel,
 }
 
 var pathToTemplates = "./templates"
@@ -37,6 +41,11 @@ func DateWithTime(t time.Time) string {
 	return t.Format("2006-01-02 15:04")
 }
 
+//Clock returns minutes after midnight as HH:MM
+func Clock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -3,7 +3,9 @@ package dbrepo
 import (
 	"database/sql"
 	"server/everydaymuslimappserver/internal/config"
+	"server/everydaymuslimappserver/internal/models"
 	"server/everydaymuslimappserver/internal/repository"
+	"sync"
 )
 
 type postgresDBRepo struct {
@@ -12,8 +14,29 @@ type postgresDBRepo struct {
 }
 
 type testDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+	App    *config.AppConfig
+	DB     *sql.DB
+	outbox *testOutbox
+	notes  *testNotes
+	invite *testSequences
+}
+
+//testOutbox is an in-memory email_outbox so tests can run the mail workers
+type testOutbox struct {
+	mu     sync.Mutex
+	emails []models.OutboxEmail
+}
+
+//testNotes is an in-memory session_notes table so tests can read back what they encrypted
+type testNotes struct {
+	mu    sync.Mutex
+	notes []models.SessionNote
+}
+
+//testSequences holds the calendar invite sequence of each reservation
+type testSequences struct {
+	mu   sync.Mutex
+	byID map[int]int
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -25,6 +48,9 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 
 func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDBRepo{
-		App: a,
+		App:    a,
+		outbox: &testOutbox{},
+		notes:  &testNotes{},
+		invite: &testSequences{byID: make(map[int]int)},
 	}
 }
@@ -2,11 +2,18 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"server/everydaymuslimappserver/internal/helpers"
 	"server/everydaymuslimappserver/internal/models"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -96,20 +103,29 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-//InsertReservation inserts a reservation to the DB
+//InsertReservation books a reservation and blocks the counselor's time for it in one transaction.
+//It returns a Conflict when the counselor already has something booked at that time, which the
+//no overlap constraint on counseling_time_restrictions catches even for bookings made at the same moment
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
 	var newID int
 
 	stmt := `insert into reservations (first_name, last_name, email, date,
-		start_time, end_time, counseling_session_id, created_at, updated_at)
-		values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+		start_time, end_time, counseling_session_id, timezone, gender, created_at, updated_at)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -117,6 +133,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartTime,
 		res.EndTime,
 		res.CounselingSessionID,
+		res.Timezone,
+		res.Gender,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -125,6 +143,56 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		return 0, err
 	}
 
+	for _, a := range res.Answers {
+		var questionID sql.NullInt64
+		if a.QuestionID > 0 {
+			questionID = sql.NullInt64{Int64: int64(a.QuestionID), Valid: true}
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into reservation_answers (reservation_id, question_id,
+			question_key, question_label, answer, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`,
+			newID, questionID, a.QuestionKey, a.QuestionLabel, a.Answer, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stmt = `insert into counseling_time_restrictions (start_time, end_time, date,
+		reservation_id, created_at, updated_at, restriction_id, counseling_session_id)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartTime,
+		res.EndTime,
+		res.Date,
+		newID,
+		time.Now(),
+		time.Now(),
+		models.RestrictionReservation,
+		res.CounselingSessionID,
+	)
+	if isExclusionViolation(err) {
+		return 0, helpers.NewConflict("counseling slot", res.StartTime.Format("2006-01-02 15:04"))
+	} else if err != nil {
+		return 0, err
+	}
+
+	err = insertReservationStatusChange(ctx, tx, models.ReservationStatusChange{
+		ReservationID: newID,
+		ToStatus:      models.ReservationRequested,
+		StartTime:     res.StartTime,
+		EndTime:       res.EndTime,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
@@ -135,104 +203,206 @@ func (m *postgresDBRepo) InsertCounselingTimeRestriction(r models.CounselingSess
 	defer cancel()
 
 	stmt := `insert into counseling_time_restrictions (start_time, end_time, date,
-			reservation_id, created_at, updated_at, restriction_id)
+			reservation_id, created_at, updated_at, restriction_id, counseling_session_id)
 			values
-			($1, $2, $3, $4, $5, $6, $7)`
+			($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	var reservationID sql.NullInt64
+	if r.ReservationID > 0 {
+		reservationID = sql.NullInt64{Int64: int64(r.ReservationID), Valid: true}
+	}
 
 	_, err := m.DB.ExecContext(
 		ctx, stmt,
 		r.StartTime,
 		r.EndTime,
 		r.Date,
-		r.ReservationID,
+		reservationID,
 		time.Now(),
 		time.Now(),
 		r.RestrictionID,
+		r.CounselingSessionID,
 	)
 
-	if err != nil {
+	if isExclusionViolation(err) {
+		return helpers.NewConflict("counseling slot", r.StartTime.Format("2006-01-02 15:04"))
+	} else if err != nil {
 		return err
 	}
 	return nil
 }
 
-//AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+//CounselingTimeRestrictions returns the bookings and owner blocks that overlap start to end,
+//with the client and counselor names filled in. counselingSessionID 0 means every counselor
+func (m *postgresDBRepo) CounselingTimeRestrictions(start, end time.Time, counselingSessionID int) ([]models.CounselingSessionTimeRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	var reservations []models.Reservation
+	var restrictions []models.CounselingSessionTimeRestriction
 
 	query := `
-		select r.id, r.first_name, r.last_name, r.email,
-		r.start_time, r.end_time, r.date, r.counseling_session_id, 
-		r.created_at, r.updated_at, r.processed,
-		cs.id, cs.counselor_name
-		from reservations r 
-		left join counseling_session cs on (r.counseling_session_id = cs.id)
-		order by r.date asc
+		select ctr.id, ctr.start_time, ctr.end_time, ctr.date, ctr.counseling_session_id,
+		coalesce(ctr.reservation_id, 0), ctr.restriction_id, ctr.created_at, ctr.updated_at,
+		cs.counselor_name,
+		coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.status, '')
+		from counseling_time_restrictions ctr
+		left join counseling_session cs on (cs.id = ctr.counseling_session_id)
+		left join reservations r on (r.id = ctr.reservation_id)
+		where ctr.start_time < $2 and ctr.end_time > $1
+		and ($3 = 0 or ctr.counseling_session_id = $3)
+		order by ctr.start_time, cs.counselor_name
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, counselingSessionID)
 	if err != nil {
-		return reservations, err
+		return restrictions, err
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
+		var i models.CounselingSessionTimeRestriction
 		err := rows.Scan(
 			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
 			&i.StartTime,
 			&i.EndTime,
 			&i.Date,
 			&i.CounselingSessionID,
+			&i.ReservationID,
+			&i.RestrictionID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
-			&i.Processed,
-			&i.CounselingSession.ID,
-			&i.CounselingSession.CounselorName,
+			&i.Session.CounselorName,
+			&i.Reservation.FirstName,
+			&i.Reservation.LastName,
+			&i.Reservation.Status,
 		)
 		if err != nil {
-			return reservations, err
+			return restrictions, err
 		}
 
-		reservations = append(reservations, i)
+		i.Session.ID = i.CounselingSessionID
+		i.Reservation.ID = i.ReservationID
+		restrictions = append(restrictions, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return restrictions, err
 	}
 
-	return reservations, nil
+	return restrictions, nil
 }
 
-//AllNewReservations returns a slice of all new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
+//DeleteOwnerBlock removes time an admin blocked out. Restrictions held by bookings are left alone
+func (m *postgresDBRepo) DeleteOwnerBlock(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
+	result, err := m.DB.ExecContext(ctx,
+		`delete from counseling_time_restrictions where id = $1 and restriction_id = $2`,
+		id, models.RestrictionOwnerBlock)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return helpers.NewNotFound("owner block", strconv.Itoa(id))
+	}
+
+	return nil
+}
+
+//reservationSortColumns maps the sorts a ReservationFilter accepts to their columns
+var reservationSortColumns = map[string]string{
+	"date":      "r.start_time",
+	"created":   "r.created_at",
+	"name":      "r.last_name, r.first_name",
+	"counselor": "cs.counselor_name",
+	"status":    "r.status",
+}
+
+//FilterReservations returns one page of the reservations matching f and how many match altogether.
+//The count comes with the rows, so it is 0 for a page past the last match
+func (m *postgresDBRepo) FilterReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
 	var reservations []models.Reservation
+	total := 0
+
+	var where []string
+	var args []interface{}
+
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("r.status = $%d", len(args)))
+	}
+
+	if f.CounselingSessionID > 0 {
+		args = append(args, f.CounselingSessionID)
+		where = append(where, fmt.Sprintf("r.counseling_session_id = $%d", len(args)))
+	}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("r.start_time >= $%d", len(args)))
+	}
+
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("r.start_time < $%d", len(args)))
+	}
+
+	if f.Search != "" {
+		args = append(args, "%"+f.Search+"%")
+		where = append(where, fmt.Sprintf(`(r.email ilike $%[1]d or r.first_name ilike $%[1]d or r.last_name ilike $%[1]d
+			or r.first_name || ' ' || r.last_name ilike $%[1]d)`, len(args)))
+	}
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email,
-		r.start_time, r.end_time, r.date, r.counseling_session_id, 
-		r.created_at, r.updated_at, r.processed
-		from reservations r 
+		r.start_time, r.end_time, r.date, r.counseling_session_id,
+		r.created_at, r.updated_at, r.status,
+		coalesce(cs.id, 0), coalesce(cs.counselor_name, ''),
+		count(*) over ()
+		from reservations r
 		left join counseling_session cs on (r.counseling_session_id = cs.id)
-		where processed = 0
-		order by r.date asc
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+
+	column, ok := reservationSortColumns[f.Sort]
+	if !ok {
+		column = reservationSortColumns["date"]
+	}
+	direction := "asc"
+	if f.Desc {
+		direction = "desc"
+	}
+	columns := strings.Split(column, ", ")
+	for i := range columns {
+		columns[i] += " " + direction
+	}
+	query += " order by " + strings.Join(columns, ", ") + ", r.id " + direction
+
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(" offset $%d", len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return reservations, total, err
 	}
 
 	defer rows.Close()
@@ -250,22 +420,23 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.CounselingSessionID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
-			&i.Processed,
-			// &i.CounselingSession.ID,
-			// &i.CounselingSession.CounselorName,
+			&i.Status,
+			&i.CounselingSession.ID,
+			&i.CounselingSession.CounselorName,
+			&total,
 		)
 		if err != nil {
-			return reservations, err
+			return reservations, total, err
 		}
 
 		reservations = append(reservations, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return reservations, total, err
 	}
-	log.Print("Reservations in DB: ", reservations)
-	return reservations, nil
+
+	return reservations, total, nil
 }
 
 //GetReservationByID gets on reservation by ID
@@ -279,8 +450,8 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 	query := `
 		select r.id, r.first_name, r.last_name, r.email,
 		r.start_time, r.end_time, r.date, 
-		r.created_at, r.updated_at, r.processed, r.counseling_session_id, 
-		cs.id, cs.counselor_name
+		r.created_at, r.updated_at, r.status, r.counseling_session_id, r.meeting_link, r.timezone,
+		r.gender, cs.id, cs.counselor_name, coalesce(cs.user_id, 0)
 		from reservations r
 		left join counseling_session cs on (r.counseling_session_id = cs.id)
 		where r.id = $1
@@ -297,39 +468,68 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.Date,
 		&res.CreatedAt,
 		&res.UpdatedAt,
-		&res.Processed,
+		&res.Status,
 		&res.CounselingSessionID,
+		&res.MeetingLink,
+		&res.Timezone,
+		&res.Gender,
 		&res.CounselingSession.ID,
 		&res.CounselingSession.CounselorName,
+		&res.CounselingSession.UserID,
 	)
 
 	if err != nil {
 		return res, err
 	}
 
+	res.Answers, err = m.reservationAnswers(ctx, res.ID)
+	if err != nil {
+		return res, err
+	}
+
 	return res, nil
 }
 
-//UpdateUser updates user in a DB
+//UpdateReservation updates a reservation and the time it blocks on the counselor's calendar
 func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
 	query := `
-		update reservations set first_name = $1,last_name = $2, email =$3, date = $4, start_time = $5, end_time = $6, updated_at = $7
-		where id = $8
+		update reservations set first_name = $1,last_name = $2, email =$3, date = $4, start_time = $5, end_time = $6, updated_at = $7,
+		meeting_link = $8
+		where id = $9
 	`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName, u.LastName, u.Email, u.Date, u.StartTime, u.EndTime, time.Now(), u.ID,
+	_, err = tx.ExecContext(ctx, query,
+		u.FirstName, u.LastName, u.Email, u.Date, u.StartTime, u.EndTime, time.Now(), u.MeetingLink, u.ID,
 	)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	//Move the blocked time with the reservation so the no overlap constraint still guards it
+	_, err = tx.ExecContext(ctx, `
+		update counseling_time_restrictions set date = $1, start_time = $2, end_time = $3, updated_at = $4
+		where reservation_id = $5
+	`, u.Date, u.StartTime, u.EndTime, time.Now(), u.ID)
+
+	if isExclusionViolation(err) {
+		return helpers.NewConflict("counseling slot", u.StartTime.Format("2006-01-02 15:04"))
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 //DeleteReservation deletes one reservation from the DB
@@ -350,20 +550,2563 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-//UpdateProcessedForReservation updates if the reservation has been processed
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+//TransitionReservation moves a reservation to a new status, and to new times when it is rescheduled,
+//and records the change. It returns a BadRequest when the reservation is no longer in the status
+//the change is from, and a Conflict when the new times overlap another booking
+func (m *postgresDBRepo) TransitionReservation(c models.ReservationStatusChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		update reservations set status = $1, date = $2, start_time = $3, end_time = $4, updated_at = $5
+		where id = $6 and status = $7
+	`, c.ToStatus, c.StartTime, c.StartTime, c.EndTime, time.Now(), c.ReservationID, c.FromStatus)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return helpers.NewBadRequest(fmt.Sprintf("reservation %d is no longer %s", c.ReservationID, c.FromStatus))
+	}
+
+	//Cancelled reservations free their time, every other status keeps it blocked at the reservation's times
+	if models.IsCancelledReservation(c.ToStatus) {
+		_, err = tx.ExecContext(ctx, `delete from counseling_time_restrictions where reservation_id = $1`, c.ReservationID)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			update counseling_time_restrictions set date = $1, start_time = $2, end_time = $3, updated_at = $4
+			where reservation_id = $5
+		`, c.StartTime, c.StartTime, c.EndTime, time.Now(), c.ReservationID)
+	}
+
+	if isExclusionViolation(err) {
+		return helpers.NewConflict("counseling slot", c.StartTime.Format("2006-01-02 15:04"))
+	} else if err != nil {
+		return err
+	}
+
+	err = insertReservationStatusChange(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//insertReservationStatusChange records a reservation status change as part of tx
+func insertReservationStatusChange(ctx context.Context, tx *sql.Tx, c models.ReservationStatusChange) error {
+	var changedBy sql.NullInt64
+	if c.ChangedBy > 0 {
+		changedBy = sql.NullInt64{Int64: int64(c.ChangedBy), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		insert into reservation_status_changes (reservation_id, from_status, to_status, changed_by,
+		start_time, end_time, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, c.ReservationID, c.FromStatus, c.ToStatus, changedBy, c.StartTime, c.EndTime, time.Now(), time.Now())
+
+	return err
+}
+
+//ReservationStatusChanges returns the status history of a reservation, oldest first
+func (m *postgresDBRepo) ReservationStatusChanges(reservationID int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select c.id, c.reservation_id, c.from_status, c.to_status, coalesce(c.changed_by, 0),
+		coalesce(u.first_name || ' ' || u.last_name, ''), c.start_time, c.end_time, c.created_at
+		from reservation_status_changes c
+		left join users u on (c.changed_by = u.id)
+		where c.reservation_id = $1
+		order by c.created_at asc, c.id asc
+	`, reservationID)
+	if err != nil {
+		return changes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.ChangedBy,
+			&c.ChangedByName,
+			&c.StartTime,
+			&c.EndTime,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return changes, err
+		}
+
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+//GetUserByEmail gets a user by email from the DB
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+	select id, first_name, last_name, email, password, access_level, created_at ,updated_at
+	from users where lower(email) = lower($1)
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	var u models.User
+
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+//GetReservationsByEmail returns every reservation made with an email address
+func (m *postgresDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
 	defer cancel()
 
+	var reservations []models.Reservation
+
 	query := `
-		update reservations set processed = $1 where id = $2
+		select r.id, r.first_name, r.last_name, r.email,
+		r.start_time, r.end_time, r.date, r.counseling_session_id,
+		r.created_at, r.updated_at, r.status, r.gender,
+		coalesce(cs.id, 0), coalesce(cs.counselor_name, '')
+		from reservations r
+		left join counseling_session cs on (r.counseling_session_id = cs.id)
+		where lower(r.email) = lower($1)
+		order by r.date asc
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	rows, err := m.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.StartTime,
+			&i.EndTime,
+			&i.Date,
+			&i.CounselingSessionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Status,
+			&i.Gender,
+			&i.CounselingSession.ID,
+			&i.CounselingSession.CounselorName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	for i := range reservations {
+		reservations[i].Answers, err = m.reservationAnswers(ctx, reservations[i].ID)
+		if err != nil {
+			return reservations, err
+		}
+	}
+
+	return reservations, nil
+}
+
+//AnonymizePersonalData removes a person's data from the DB. Reservations and audit events are kept
+//for the admin history but their names, email and snapshots are removed
+func (m *postgresDBRepo) AnonymizePersonalData(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		delete from reservation_answers where reservation_id in
+		(select id from reservations where lower(email) = lower($1))
+	`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from session_notes where reservation_id in
+		(select id from reservations where lower(email) = lower($1))
+	`, email)
+	if err != nil {
+		return err
+	}
+
+	//The audit log keeps what was done, but not the snapshots, address or connection details of the person
+	_, err = tx.ExecContext(ctx, `
+		update audit_events set before = '', after = ''
+		where target in (select concat('reservation:', id) from reservations where lower(email) = lower($1))
+		or position(lower($1) in lower(concat(before, after))) > 0
+	`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update audit_events set target = 'anonymized' where lower(target) = lower($1)`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update audit_events set ip_address = '', user_agent = ''
+		where actor_id in (select id from users where lower(email) = lower($1))
+	`, email)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		update reservations set first_name = 'Anonymized', last_name = '', gender = '',
+		email = concat('anonymized-', id, '@anonymized.invalid'), updated_at = $1
+		where lower(email) = lower($2)
+	`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from newsletter_subscribers where lower(email) = lower($1)`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from users where lower(email) = lower($1)`, email)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//InsertAuditEvent records an admin or auth action in the audit log
+func (m *postgresDBRepo) InsertAuditEvent(e models.AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `insert into audit_events (actor_id, action, target, before, after,
+		ip_address, user_agent, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		e.ActorID,
+		e.Action,
+		e.Target,
+		e.Before,
+		e.After,
+		e.IPAddress,
+		e.UserAgent,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//AuditEvents returns the audit events matching the filter, newest first
+func (m *postgresDBRepo) AuditEvents(f models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
+	var events []models.AuditEvent
+
+	var where []string
+	var args []interface{}
+
+	if f.Action != "" {
+		args = append(args, f.Action+"%")
+		where = append(where, fmt.Sprintf("a.action like $%d", len(args)))
+	}
+
+	if f.Actor != "" {
+		args = append(args, "%"+f.Actor+"%")
+		where = append(where, fmt.Sprintf("u.email ilike $%d", len(args)))
+	}
+
+	if f.ActorID > 0 {
+		args = append(args, f.ActorID)
+		where = append(where, fmt.Sprintf("a.actor_id = $%d", len(args)))
+	}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("a.created_at >= $%d", len(args)))
+	}
+
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("a.created_at < $%d", len(args)))
+	}
+
+	query := `
+		select a.id, a.actor_id, coalesce(u.email, ''), a.action, a.target,
+		a.before, a.after, a.ip_address, a.user_agent, a.created_at
+		from audit_events a
+		left join users u on (a.actor_id = u.id)
+	`
+
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+
+	query += " order by a.created_at desc"
+
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return events, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.ActorEmail,
+			&e.Action,
+			&e.Target,
+			&e.Before,
+			&e.After,
+			&e.IPAddress,
+			&e.UserAgent,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return events, err
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+//isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+//isExclusionViolation reports whether err is postgres rejecting a row that breaks an exclusion
+//constraint, such as two bookings for one counselor at the same time
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+//scanNewsletterSubscriber scans one newsletter_subscribers row
+func scanNewsletterSubscriber(row interface{ Scan(...interface{}) error }) (models.NewsletterSubscriber, error) {
+	var s models.NewsletterSubscriber
+	var confirmedAt, unsubscribedAt sql.NullTime
+
+	err := row.Scan(
+		&s.ID,
+		&s.FirstName,
+		&s.LastName,
+		&s.Email,
+		&s.Status,
+		&s.Token,
+		&confirmedAt,
+		&unsubscribedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return s, err
+	}
+
+	s.ConfirmedAt = confirmedAt.Time
+	s.UnsubscribedAt = unsubscribedAt.Time
+
+	return s, nil
+}
+
+//GetNewsletterSubscriberByID gets a newsletter subscriber by ID
+func (m *postgresDBRepo) GetNewsletterSubscriberByID(id int) (models.NewsletterSubscriber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+		select id, first_name, last_name, email, status, token,
+		confirmed_at, unsubscribed_at, created_at, updated_at
+		from newsletter_subscribers where id = $1
+	`
+
+	return scanNewsletterSubscriber(m.DB.QueryRowContext(ctx, query, id))
+}
+
+//GetNewsletterSubscriberByEmail gets a newsletter subscriber by email
+func (m *postgresDBRepo) GetNewsletterSubscriberByEmail(email string) (models.NewsletterSubscriber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+		select id, first_name, last_name, email, status, token,
+		confirmed_at, unsubscribed_at, created_at, updated_at
+		from newsletter_subscribers where lower(email) = lower($1)
+	`
+
+	return scanNewsletterSubscriber(m.DB.QueryRowContext(ctx, query, email))
+}
+
+//InsertNewsletterSubscriber inserts a newsletter subscriber into the DB
+func (m *postgresDBRepo) InsertNewsletterSubscriber(s models.NewsletterSubscriber) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into newsletter_subscribers (first_name, last_name, email, status, token,
+		created_at, updated_at)
+		values ($1, $2, lower($3), $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.FirstName,
+		s.LastName,
+		s.Email,
+		s.Status,
+		s.Token,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, helpers.NewConflict("newsletter subscriber", s.Email)
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//UpdateNewsletterSubscriber updates a newsletter subscriber in the DB
+func (m *postgresDBRepo) UpdateNewsletterSubscriber(s models.NewsletterSubscriber) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+		update newsletter_subscribers set first_name = $1, last_name = $2, status = $3, token = $4,
+		confirmed_at = $5, unsubscribed_at = $6, updated_at = $7
+		where id = $8
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		s.FirstName,
+		s.LastName,
+		s.Status,
+		s.Token,
+		sql.NullTime{Time: s.ConfirmedAt, Valid: !s.ConfirmedAt.IsZero()},
+		sql.NullTime{Time: s.UnsubscribedAt, Valid: !s.UnsubscribedAt.IsZero()},
+		time.Now(),
+		s.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//ConfirmNewsletterSubscriber confirms the subscriber holding a confirmation token
+func (m *postgresDBRepo) ConfirmNewsletterSubscriber(token string) (models.NewsletterSubscriber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	//The token is replaced so the confirmation link only works once
+	newToken, err := helpers.RandomToken()
+	if err != nil {
+		return models.NewsletterSubscriber{}, err
+	}
+
+	stmt := `
+		update newsletter_subscribers set status = $1, confirmed_at = coalesce(confirmed_at, $2),
+		unsubscribed_at = null, updated_at = $2, token = $5
+		where token = $3 and status <> $4
+		returning id, first_name, last_name, email, status, token,
+		confirmed_at, unsubscribed_at, created_at, updated_at
+	`
+
+	s, err := scanNewsletterSubscriber(m.DB.QueryRowContext(ctx, stmt,
+		models.SubscriberConfirmed, time.Now(), token, models.SubscriberUnsubscribed, newToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, helpers.NewNotFound("confirmation token", token)
+	}
+
+	return s, err
+}
+
+//scanCampaign scans one newsletter_campaigns row
+func scanCampaign(row interface{ Scan(...interface{}) error }) (models.Campaign, error) {
+	var c models.Campaign
+	var scheduledAt, sentAt sql.NullTime
+
+	err := row.Scan(
+		&c.ID,
+		&c.Subject,
+		&c.Body,
+		&c.Audience,
+		&c.Status,
+		&scheduledAt,
+		&sentAt,
+		&c.CreatedBy,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	c.ScheduledAt = scheduledAt.Time
+	c.SentAt = sentAt.Time
+
+	return c, nil
+}
+
+//queryCampaigns runs a query returning newsletter_campaigns rows
+func (m *postgresDBRepo) queryCampaigns(query string, args ...interface{}) ([]models.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var campaigns []models.Campaign
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return campaigns, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return campaigns, err
+		}
+
+		campaigns = append(campaigns, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return campaigns, err
+	}
+
+	return campaigns, nil
+}
+
+//AllCampaigns returns every newsletter campaign, newest first
+func (m *postgresDBRepo) AllCampaigns() ([]models.Campaign, error) {
+	return m.queryCampaigns(`
+		select id, subject, body, audience, status, scheduled_at, sent_at,
+		created_by, created_at, updated_at
+		from newsletter_campaigns
+		order by created_at desc
+	`)
+}
+
+//GetCampaignByID gets a newsletter campaign by ID
+func (m *postgresDBRepo) GetCampaignByID(id int) (models.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+		select id, subject, body, audience, status, scheduled_at, sent_at,
+		created_by, created_at, updated_at
+		from newsletter_campaigns where id = $1
+	`
+
+	return scanCampaign(m.DB.QueryRowContext(ctx, query, id))
+}
+
+//InsertCampaign inserts a newsletter campaign into the DB
+func (m *postgresDBRepo) InsertCampaign(c models.Campaign) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into newsletter_campaigns (subject, body, audience, status, scheduled_at,
+		created_by, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		c.Subject,
+		c.Body,
+		c.Audience,
+		c.Status,
+		sql.NullTime{Time: c.ScheduledAt, Valid: !c.ScheduledAt.IsZero()},
+		c.CreatedBy,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//UpdateCampaign updates a newsletter campaign in the DB
+func (m *postgresDBRepo) UpdateCampaign(c models.Campaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+		update newsletter_campaigns set subject = $1, body = $2, audience = $3, status = $4,
+		scheduled_at = $5, updated_at = $6
+		where id = $7
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.Subject,
+		c.Body,
+		c.Audience,
+		c.Status,
+		sql.NullTime{Time: c.ScheduledAt, Valid: !c.ScheduledAt.IsZero()},
+		time.Now(),
+		c.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//DueCampaigns returns scheduled campaigns whose time has come and campaigns that were
+//interrupted while sending
+func (m *postgresDBRepo) DueCampaigns(now time.Time) ([]models.Campaign, error) {
+	return m.queryCampaigns(`
+		select id, subject, body, audience, status, scheduled_at, sent_at,
+		created_by, created_at, updated_at
+		from newsletter_campaigns
+		where (status = $1 and scheduled_at <= $2) or status = $3
+		order by scheduled_at asc
+	`, models.CampaignScheduled, now, models.CampaignSending)
+}
+
+//StartCampaign marks a campaign as sending and adds every confirmed subscriber in the
+//campaign audience as a recipient
+func (m *postgresDBRepo) StartCampaign(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update newsletter_campaigns set status = $1, updated_at = $2 where id = $3`,
+		models.CampaignSending, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	var audience string
+	err = tx.QueryRowContext(ctx, `select audience from newsletter_campaigns where id = $1`, id).Scan(&audience)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		insert into newsletter_campaign_recipients (campaign_id, subscriber_id, status, created_at, updated_at)
+		select $1, s.id, $2, $3, $3
+		from newsletter_subscribers s
+		where s.status = $4
+	`
+	args := []interface{}{id, models.RecipientPending, time.Now(), models.SubscriberConfirmed}
+
+	//Audiences other than "all" are a subscriber tag, stored as tag:<id>
+	if strings.HasPrefix(audience, "tag:") {
+		tagID, err := strconv.Atoi(strings.TrimPrefix(audience, "tag:"))
+		if err != nil {
+			return err
+		}
+		args = append(args, tagID)
+		stmt += ` and exists (select 1 from subscriber_tag_assignments a
+			where a.subscriber_id = s.id and a.tag_id = $5)`
+	}
+
+	stmt += " on conflict (campaign_id, subscriber_id) do nothing"
+
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//FinishCampaign marks a campaign as sent
+func (m *postgresDBRepo) FinishCampaign(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update newsletter_campaigns set status = $1, sent_at = $2, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.CampaignSent, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//PendingCampaignRecipients returns recipients of a campaign who have not been sent the campaign yet
+func (m *postgresDBRepo) PendingCampaignRecipients(campaignID, limit int) ([]models.CampaignRecipient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var recipients []models.CampaignRecipient
+
+	query := `
+		select r.id, r.campaign_id, r.subscriber_id, r.status, r.error,
+		s.id, s.first_name, s.last_name, s.email, s.status
+		from newsletter_campaign_recipients r
+		left join newsletter_subscribers s on (r.subscriber_id = s.id)
+		where r.campaign_id = $1 and r.status = $2
+		order by r.id asc
+		limit $3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, campaignID, models.RecipientPending, limit)
+	if err != nil {
+		return recipients, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.CampaignRecipient
+		err := rows.Scan(
+			&i.ID,
+			&i.CampaignID,
+			&i.SubscriberID,
+			&i.Status,
+			&i.Error,
+			&i.Subscriber.ID,
+			&i.Subscriber.FirstName,
+			&i.Subscriber.LastName,
+			&i.Subscriber.Email,
+			&i.Subscriber.Status,
+		)
+		if err != nil {
+			return recipients, err
+		}
+
+		recipients = append(recipients, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return recipients, err
+	}
+
+	return recipients, nil
+}
+
+//UpdateCampaignRecipientStatus records the delivery status for one campaign recipient
+func (m *postgresDBRepo) UpdateCampaignRecipientStatus(id int, status, errorMessage string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+		update newsletter_campaign_recipients set status = $1, error = $2, updated_at = $3
+		where id = $4
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, errorMessage, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//QueueCampaignEmail adds a campaign email to the outbox and marks its recipient as queued in one transaction
+func (m *postgresDBRepo) QueueCampaignEmail(recipientID int, mail models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = insertOutboxEmail(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update newsletter_campaign_recipients set status = $1, error = '', queued_at = $2, updated_at = $2
+		where id = $3
+	`, models.RecipientQueued, time.Now(), recipientID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//CampaignRecipientCounts returns the number of recipients of a campaign in each status
+func (m *postgresDBRepo) CampaignRecipientCounts(campaignID int) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	counts := make(map[string]int)
+
+	query := `
+		select status, count(*) from newsletter_campaign_recipients
+		where campaign_id = $1
+		group by status
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, campaignID)
+	if err != nil {
+		return counts, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
+
+//FilterNewsletterSubscribers returns the newsletter subscribers matching the filter with their tags
+func (m *postgresDBRepo) FilterNewsletterSubscribers(f models.SubscriberFilter) ([]models.NewsletterSubscriber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	defer cancel()
+
+	var subscribers []models.NewsletterSubscriber
+
+	var where []string
+	var args []interface{}
+
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("s.status = $%d", len(args)))
+	}
+
+	if f.TagID > 0 {
+		args = append(args, f.TagID)
+		where = append(where, fmt.Sprintf(`exists (select 1 from subscriber_tag_assignments sa
+			where sa.subscriber_id = s.id and sa.tag_id = $%d)`, len(args)))
+	}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("s.created_at >= $%d", len(args)))
+	}
+
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("s.created_at < $%d", len(args)))
+	}
+
+	query := `
+		select s.id, s.first_name, s.last_name, s.email, s.status, s.token,
+		s.confirmed_at, s.unsubscribed_at, s.created_at, s.updated_at,
+		coalesce(string_agg(t.name, ',' order by t.name), '')
+		from newsletter_subscribers s
+		left join subscriber_tag_assignments a on (a.subscriber_id = s.id)
+		left join subscriber_tags t on (a.tag_id = t.id)
+	`
+
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+
+	query += " group by s.id order by s.created_at desc"
+
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return subscribers, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.NewsletterSubscriber
+		var confirmedAt, unsubscribedAt sql.NullTime
+		var tags string
+
+		err := rows.Scan(
+			&s.ID,
+			&s.FirstName,
+			&s.LastName,
+			&s.Email,
+			&s.Status,
+			&s.Token,
+			&confirmedAt,
+			&unsubscribedAt,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&tags,
+		)
+		if err != nil {
+			return subscribers, err
+		}
+
+		s.ConfirmedAt = confirmedAt.Time
+		s.UnsubscribedAt = unsubscribedAt.Time
+		if tags != "" {
+			s.Tags = strings.Split(tags, ",")
+		}
+
+		subscribers = append(subscribers, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return subscribers, err
+	}
+
+	return subscribers, nil
+}
+
+//ImportNewsletterSubscribers adds confirmed subscribers in one transaction, skipping addresses
+//that already exist. When tagID is set every imported or existing address gets the tag.
+//It returns the number of new subscribers
+func (m *postgresDBRepo) ImportNewsletterSubscribers(subscribers []models.NewsletterSubscriber, tagID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	insert := `insert into newsletter_subscribers (first_name, last_name, email, status, token,
+		confirmed_at, created_at, updated_at)
+		values ($1, $2, lower($3), $4, $5, $6, $6, $6)
+		on conflict (email) do nothing
+		returning id`
+
+	imported := 0
+
+	for _, s := range subscribers {
+		var id int
+		err := tx.QueryRowContext(ctx, insert,
+			s.FirstName,
+			s.LastName,
+			s.Email,
+			models.SubscriberConfirmed,
+			s.Token,
+			time.Now(),
+		).Scan(&id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			err = tx.QueryRowContext(ctx, `select id from newsletter_subscribers where email = lower($1)`, s.Email).Scan(&id)
+		} else if err == nil {
+			imported++
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if tagID > 0 {
+			_, err = tx.ExecContext(ctx, `insert into subscriber_tag_assignments (subscriber_id, tag_id, created_at, updated_at)
+				values ($1, $2, $3, $3) on conflict (subscriber_id, tag_id) do nothing`, id, tagID, time.Now())
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return imported, tx.Commit()
+}
+
+//SubscriberTags returns every subscriber tag with the number of subscribers that have it
+func (m *postgresDBRepo) SubscriberTags() ([]models.SubscriberTag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var tags []models.SubscriberTag
+
+	query := `
+		select t.id, t.name, count(a.id), t.created_at, t.updated_at
+		from subscriber_tags t
+		left join subscriber_tag_assignments a on (a.tag_id = t.id)
+		group by t.id
+		order by t.name asc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return tags, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.SubscriberTag
+		err := rows.Scan(&t.ID, &t.Name, &t.Subscribers, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return tags, err
+		}
+
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tags, err
+	}
+
+	return tags, nil
+}
+
+//InsertSubscriberTag inserts a subscriber tag into the DB
+func (m *postgresDBRepo) InsertSubscriberTag(name string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	err := m.DB.QueryRowContext(ctx,
+		`insert into subscriber_tags (name, created_at, updated_at) values ($1, $2, $2) returning id`,
+		name, time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, helpers.NewConflict("subscriber tag", name)
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//TagNewsletterSubscribers adds a tag to newsletter subscribers
+func (m *postgresDBRepo) TagNewsletterSubscribers(tagID int, subscriberIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	stmt := `insert into subscriber_tag_assignments (subscriber_id, tag_id, created_at, updated_at)
+		values ($1, $2, $3, $3) on conflict (subscriber_id, tag_id) do nothing`
+
+	for _, id := range subscriberIDs {
+		_, err = tx.ExecContext(ctx, stmt, id, tagID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//UntagNewsletterSubscribers removes a tag from newsletter subscribers
+func (m *postgresDBRepo) UntagNewsletterSubscribers(tagID int, subscriberIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	stmt := `delete from subscriber_tag_assignments where subscriber_id = $1 and tag_id = $2`
+
+	for _, id := range subscriberIDs {
+		_, err = tx.ExecContext(ctx, stmt, id, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//scanOutboxEmail scans one email_outbox row
+func scanOutboxEmail(row interface{ Scan(...interface{}) error }) (models.OutboxEmail, error) {
+	var e models.OutboxEmail
+	var headers, attachments string
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&e.Mail.To,
+		&e.Mail.From,
+		&e.Mail.Subject,
+		&e.Mail.Content,
+		&e.Mail.TextContent,
+		&e.Mail.Template,
+		&headers,
+		&attachments,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptAt,
+		&e.LastError,
+		&sentAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	e.SentAt = sentAt.Time
+
+	if err = json.Unmarshal([]byte(headers), &e.Mail.Headers); err != nil {
+		return e, err
+	}
+
+	if err = json.Unmarshal([]byte(attachments), &e.Mail.Attachments); err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+//queryOutboxEmails runs a query returning email_outbox rows
+func (m *postgresDBRepo) queryOutboxEmails(query string, args ...interface{}) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return emails, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+//InsertOutboxEmail adds an email to the outbox to be sent by the mail workers
+func (m *postgresDBRepo) InsertOutboxEmail(mail models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	return insertOutboxEmail(ctx, m.DB, mail)
+}
+
+//queryRower runs a query returning one row, on the DB or inside a transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+//insertOutboxEmail adds an email to the outbox with q, so it can be part of a transaction
+func insertOutboxEmail(ctx context.Context, q queryRower, mail models.MailData) (int, error) {
+	headers, err := json.Marshal(mail.Headers)
+	if err != nil {
+		return 0, err
+	}
+
+	attachments, err := json.Marshal(mail.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+
+	stmt := `insert into email_outbox (to_address, from_address, subject, content, text_content, template, headers, attachments,
+		status, next_attempt_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10, $10) returning id`
+
+	err = q.QueryRowContext(ctx, stmt,
+		mail.To,
+		mail.From,
+		mail.Subject,
+		mail.Content,
+		mail.TextContent,
+		mail.Template,
+		string(headers),
+		string(attachments),
+		models.OutboxPending,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//DueReminders returns the reminders due at now for confirmed and rescheduled reservations that have not
+//been sent. A reminder is only due for reservations booked before the reminder time, and is sent again
+//when a reservation moves to a new time
+func (m *postgresDBRepo) DueReminders(offsets []time.Duration, now time.Time) ([]models.Reminder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
+	var reminders []models.Reminder
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.start_time, r.end_time, r.date,
+		r.counseling_session_id, r.status, r.meeting_link, r.timezone,
+		coalesce(cs.id, 0), coalesce(cs.counselor_name, '')
+		from reservations r
+		left join counseling_session cs on (r.counseling_session_id = cs.id)
+		left join reservation_reminders rr on (rr.reservation_id = r.id
+			and rr.offset_minutes = $1 and rr.start_time = r.start_time)
+		where r.status in ('confirmed', 'rescheduled') and rr.id is null
+		and r.start_time > $2 and r.start_time - make_interval(mins => $1) <= $2
+		and r.created_at <= r.start_time - make_interval(mins => $1)
+		order by r.start_time asc
+	`
+
+	for _, offset := range offsets {
+		rows, err := m.DB.QueryContext(ctx, query, int(offset.Minutes()), now)
+		if err != nil {
+			return reminders, err
+		}
+
+		for rows.Next() {
+			var res models.Reservation
+			err := rows.Scan(
+				&res.ID,
+				&res.FirstName,
+				&res.LastName,
+				&res.Email,
+				&res.StartTime,
+				&res.EndTime,
+				&res.Date,
+				&res.CounselingSessionID,
+				&res.Status,
+				&res.MeetingLink,
+				&res.Timezone,
+				&res.CounselingSession.ID,
+				&res.CounselingSession.CounselorName,
+			)
+			if err != nil {
+				rows.Close()
+				return reminders, err
+			}
+
+			reminders = append(reminders, models.Reminder{Reservation: res, Offset: offset})
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return reminders, err
+		}
+	}
+
+	return reminders, nil
+}
+
+//QueueReminder records a reminder as sent and adds its email to the outbox in one transaction, so each
+//reminder is queued exactly once even when several servers run the scheduler. It returns false when
+//the reminder was already queued
+func (m *postgresDBRepo) QueueReminder(rem models.Reminder, mail models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		insert into reservation_reminders (reservation_id, offset_minutes, start_time, created_at, updated_at)
+		values ($1, $2, $3, $4, $4)
+		on conflict (reservation_id, offset_minutes, start_time) do nothing
+	`, rem.Reservation.ID, int(rem.Offset.Minutes()), rem.Reservation.StartTime, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if rows == 0 {
+		return false, nil
+	}
+
+	_, err = insertOutboxEmail(ctx, tx, mail)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//ClaimOutboxEmails locks up to limit emails that are due to be sent and marks them as sending.
+//Emails left sending by a worker that stopped more than ten minutes ago are claimed again
+func (m *postgresDBRepo) ClaimOutboxEmails(limit int) ([]models.OutboxEmail, error) {
+	return m.queryOutboxEmails(`
+		update email_outbox set status = $1, locked_at = $2, attempts = attempts + 1, updated_at = $2
+		where id in (
+			select id from email_outbox
+			where (status = $3 and next_attempt_at <= $2)
+			or (status = $1 and locked_at < $4)
+			order by next_attempt_at asc
+			limit $5
+			for update skip locked
+		)
+		returning id, to_address, from_address, subject, content, text_content, template, headers, attachments,
+		status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at
+	`, models.OutboxSending, time.Now(), models.OutboxPending, time.Now().Add(-10*time.Minute), limit)
+}
+
+//MarkOutboxEmailSent records that an outbox email was sent
+func (m *postgresDBRepo) MarkOutboxEmailSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update email_outbox set status = $1, sent_at = $2, locked_at = null, last_error = '', updated_at = $2
+		where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxSent, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//MarkOutboxEmailFailed records a failed attempt. The email is tried again at nextAttempt,
+//or moved to the dead letter state when dead is true
+func (m *postgresDBRepo) MarkOutboxEmailFailed(id int, errorMessage string, nextAttempt time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	status := models.OutboxPending
+	if dead {
+		status = models.OutboxDead
+	}
+
+	stmt := `update email_outbox set status = $1, last_error = $2, next_attempt_at = $3, locked_at = null, updated_at = $4
+		where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, errorMessage, nextAttempt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//FilterOutboxEmails returns the newest outbox emails, optionally only those in one status
+func (m *postgresDBRepo) FilterOutboxEmails(status string, limit int) ([]models.OutboxEmail, error) {
+	query := `
+		select id, to_address, from_address, subject, content, text_content, template, headers, attachments,
+		status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at
+		from email_outbox
+		where ($1 = '' or status = $1)
+		order by created_at desc
+		limit $2
+	`
+
+	return m.queryOutboxEmails(query, status, limit)
+}
+
+//OutboxCounts returns the number of outbox emails in each status
+func (m *postgresDBRepo) OutboxCounts() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	counts := make(map[string]int)
+
+	rows, err := m.DB.QueryContext(ctx, `select status, count(*) from email_outbox group by status`)
+	if err != nil {
+		return counts, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
+
+//RetryOutboxEmail queues a failed or dead outbox email to be sent again straight away
+func (m *postgresDBRepo) RetryOutboxEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update email_outbox set status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+		where id = $3 and status in ($1, $4)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, models.OutboxPending, time.Now(), id, models.OutboxDead)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return helpers.NewNotFound("retryable outbox email", strconv.Itoa(id))
+	}
+
+	return nil
+}
+
+//SearchAvailability returns the free slots of sessionLength between start and end for each active counselor,
+//or only for counselingSessionID when it is not 0. Only the times counselors work that day are searched,
+//and times taken by reservations or time restrictions are left out
+func (m *postgresDBRepo) SearchAvailability(start, end time.Time, sessionLength time.Duration, counselingSessionID int) ([]models.Slot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var slots []models.Slot
+
+	counselors, err := m.AllCounselors()
+	if err != nil {
+		return slots, err
+	}
+
+	windows := make(map[int][]models.AvailabilityWindow)
+	rows, err := m.DB.QueryContext(ctx, `
+		select id, counseling_session_id, weekday, start_minute, end_minute
+		from counselor_availability
+		where weekday = $1 and ($2 = 0 or counseling_session_id = $2)
+	`, int(start.Weekday()), counselingSessionID)
+	if err != nil {
+		return slots, err
+	}
+
+	for rows.Next() {
+		var w models.AvailabilityWindow
+		if err := rows.Scan(&w.ID, &w.CounselingSessionID, &w.Weekday, &w.StartMinute, &w.EndMinute); err != nil {
+			rows.Close()
+			return slots, err
+		}
+		windows[w.CounselingSessionID] = append(windows[w.CounselingSessionID], w)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return slots, err
+	}
+
+	exceptions := make(map[int][]models.ScheduleException)
+	rows, err = m.DB.QueryContext(ctx, `
+		select id, counseling_session_id, start_date, end_date, closed, start_minute, end_minute
+		from counselor_schedule_exceptions
+		where start_date <= $1 and end_date >= $1 and ($2 = 0 or counseling_session_id = $2)
+	`, start.Format("2006-01-02"), counselingSessionID)
+	if err != nil {
+		return slots, err
+	}
+
+	for rows.Next() {
+		var e models.ScheduleException
+		err := rows.Scan(&e.ID, &e.CounselingSessionID, &e.StartDate, &e.EndDate, &e.Closed, &e.StartMinute, &e.EndMinute)
+		if err != nil {
+			rows.Close()
+			return slots, err
+		}
+		exceptions[e.CounselingSessionID] = append(exceptions[e.CounselingSessionID], e)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return slots, err
+	}
+
+	rows, err = m.DB.QueryContext(ctx, `
+		select counseling_session_id, start_time, end_time from counseling_time_restrictions
+		where start_time < $2 and end_time > $1 and ($3 = 0 or counseling_session_id = $3)
+		union all
+		select counseling_session_id, start_time, end_time from reservations
+		where start_time < $2 and end_time > $1 and ($3 = 0 or counseling_session_id = $3)
+		and status not in ('cancelled_by_user', 'cancelled_by_staff')
+	`, start, end, counselingSessionID)
+	if err != nil {
+		return slots, err
+	}
+
+	defer rows.Close()
+
+	busy := make(map[int][]timeRange)
+	for rows.Next() {
+		var id int
+		var t timeRange
+		if err := rows.Scan(&id, &t.start, &t.end); err != nil {
+			return slots, err
+		}
+		t.start, t.end = localClock(t.start), localClock(t.end)
+		busy[id] = append(busy[id], t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return slots, err
+	}
+
+	for _, c := range counselors {
+		if !c.Active || (counselingSessionID != 0 && c.ID != counselingSessionID) {
+			continue
+		}
+		slots = append(slots, availableSlots(c, start, end, sessionLength, windows[c.ID], exceptions[c.ID], busy[c.ID])...)
+	}
+
+	return slots, nil
+}
+
+//counselorColumns are the counseling_session columns read by scanCounselor
+const counselorColumns = `cs.id, cs.counselor_name, coalesce(cs.user_id, 0), coalesce(u.email, ''),
+	cs.bio, cs.gender, cs.languages, cs.specialties, cs.active, cs.created_at, cs.updated_at`
+
+//scanCounselor scans one counseling_session row selected with counselorColumns
+func scanCounselor(row interface{ Scan(...interface{}) error }) (models.CounselingSession, error) {
+	var c models.CounselingSession
+	var languages, specialties string
+
+	err := row.Scan(
+		&c.ID,
+		&c.CounselorName,
+		&c.UserID,
+		&c.UserEmail,
+		&c.Bio,
+		&c.Gender,
+		&languages,
+		&specialties,
+		&c.Active,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	c.Languages = splitList(languages)
+	c.Specialties = splitList(specialties)
+
+	return c, nil
+}
+
+//splitList splits a comma separated column into its values
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+//AllCounselors returns every counselor
+func (m *postgresDBRepo) AllCounselors() ([]models.CounselingSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var counselors []models.CounselingSession
+
+	query := `select ` + counselorColumns + `
+		from counseling_session cs
+		left join users u on (u.id = cs.user_id)
+		order by cs.counselor_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return counselors, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCounselor(rows)
+		if err != nil {
+			return counselors, err
+		}
+		counselors = append(counselors, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return counselors, err
+	}
+
+	return counselors, nil
+}
+
+//GetCounselorByID returns one counselor
+func (m *postgresDBRepo) GetCounselorByID(id int) (models.CounselingSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `select ` + counselorColumns + `
+		from counseling_session cs
+		left join users u on (u.id = cs.user_id)
+		where cs.id = $1`
+
+	return scanCounselor(m.DB.QueryRowContext(ctx, query, id))
+}
+
+//GetCounselorByUserID returns the counselor linked to a user account
+func (m *postgresDBRepo) GetCounselorByUserID(userID int) (models.CounselingSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `select ` + counselorColumns + `
+		from counseling_session cs
+		left join users u on (u.id = cs.user_id)
+		where cs.user_id = $1`
+
+	return scanCounselor(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+//InsertCounselor inserts a counselor into the DB. A user account can only be linked to one counselor
+func (m *postgresDBRepo) InsertCounselor(c models.CounselingSession) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into counseling_session (counselor_name, user_id, bio, gender, languages, specialties,
+		active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		c.CounselorName,
+		sql.NullInt64{Int64: int64(c.UserID), Valid: c.UserID > 0},
+		c.Bio,
+		c.Gender,
+		strings.Join(c.Languages, ","),
+		strings.Join(c.Specialties, ","),
+		c.Active,
+		time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, helpers.NewConflict("counselor for user", strconv.Itoa(c.UserID))
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//UpdateCounselor updates a counselor in the DB
+func (m *postgresDBRepo) UpdateCounselor(c models.CounselingSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+		update counseling_session set counselor_name = $1, user_id = $2, bio = $3, gender = $4,
+		languages = $5, specialties = $6, active = $7, updated_at = $8
+		where id = $9
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.CounselorName,
+		sql.NullInt64{Int64: int64(c.UserID), Valid: c.UserID > 0},
+		c.Bio,
+		c.Gender,
+		strings.Join(c.Languages, ","),
+		strings.Join(c.Specialties, ","),
+		c.Active,
+		time.Now(),
+		c.ID,
+	)
+
+	if isUniqueViolation(err) {
+		return helpers.NewConflict("counselor for user", strconv.Itoa(c.UserID))
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//CounselorAvailability returns a counselor's weekly availability windows
+func (m *postgresDBRepo) CounselorAvailability(counselingSessionID int) ([]models.AvailabilityWindow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var windows []models.AvailabilityWindow
+
+	query := `
+		select id, counseling_session_id, weekday, start_minute, end_minute
+		from counselor_availability
+		where counseling_session_id = $1
+		order by weekday, start_minute
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, counselingSessionID)
+	if err != nil {
+		return windows, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var w models.AvailabilityWindow
+		err := rows.Scan(&w.ID, &w.CounselingSessionID, &w.Weekday, &w.StartMinute, &w.EndMinute)
+		if err != nil {
+			return windows, err
+		}
+		windows = append(windows, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return windows, err
+	}
+
+	return windows, nil
+}
+
+//ReplaceCounselorAvailability replaces all of a counselor's weekly availability windows
+func (m *postgresDBRepo) ReplaceCounselorAvailability(counselingSessionID int, windows []models.AvailabilityWindow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from counselor_availability where counseling_session_id = $1`, counselingSessionID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into counselor_availability (counseling_session_id, weekday, start_minute, end_minute,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5)`
+
+	for _, w := range windows {
+		_, err = tx.ExecContext(ctx, stmt, counselingSessionID, int(w.Weekday), w.StartMinute, w.EndMinute, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//CounselorScheduleExceptions returns a counselor's schedule exceptions that have not ended yet
+func (m *postgresDBRepo) CounselorScheduleExceptions(counselingSessionID int) ([]models.ScheduleException, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var exceptions []models.ScheduleException
+
+	query := `
+		select id, counseling_session_id, start_date, end_date, closed, start_minute, end_minute, note,
+		created_at, updated_at
+		from counselor_schedule_exceptions
+		where counseling_session_id = $1 and end_date >= current_date
+		order by start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, counselingSessionID)
+	if err != nil {
+		return exceptions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ScheduleException
+		err := rows.Scan(
+			&e.ID,
+			&e.CounselingSessionID,
+			&e.StartDate,
+			&e.EndDate,
+			&e.Closed,
+			&e.StartMinute,
+			&e.EndMinute,
+			&e.Note,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return exceptions, err
+		}
+		exceptions = append(exceptions, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return exceptions, err
+	}
+
+	return exceptions, nil
+}
+
+//InsertScheduleException inserts a schedule exception into the DB
+func (m *postgresDBRepo) InsertScheduleException(e models.ScheduleException) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into counselor_schedule_exceptions (counseling_session_id, start_date, end_date, closed,
+		start_minute, end_minute, note, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.CounselingSessionID,
+		e.StartDate,
+		e.EndDate,
+		e.Closed,
+		e.StartMinute,
+		e.EndMinute,
+		e.Note,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//DeleteScheduleException deletes one of a counselor's schedule exceptions
+func (m *postgresDBRepo) DeleteScheduleException(counselingSessionID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`delete from counselor_schedule_exceptions where id = $1 and counseling_session_id = $2`,
+		id, counselingSessionID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return helpers.NewNotFound("schedule exception", strconv.Itoa(id))
+	}
+
+	return nil
+}
+
+//RecordContentView counts one view of a hadith, ayah, dua or surah on day
+func (m *postgresDBRepo) RecordContentView(contentType, contentID string, day time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+		insert into content_views (content_type, content_id, day, views, created_at, updated_at)
+		values ($1, $2, $3, 1, $4, $4)
+		on conflict (content_type, content_id, day)
+		do update set views = content_views.views + 1, updated_at = excluded.updated_at
+	`, contentType, contentID, day.Format("2006-01-02"), time.Now())
+
+	return err
+}
+
+//dashboardRateDays is how far back the dashboard looks for completed and missed sessions
+const dashboardRateDays = 90
+
+//weekCounts counts the rows of table per week for weeks weeks from start, by the time in column.
+//table and column are never user input
+func (m *postgresDBRepo) weekCounts(ctx context.Context, table, column string, start time.Time, weeks int) ([]models.WeekCount, error) {
+	var counts []models.WeekCount
+
+	query := fmt.Sprintf(`
+		select w.week, count(t.id)
+		from generate_series($1::timestamp, $1::timestamp + ($2 - 1) * interval '1 week', interval '1 week') w(week)
+		left join %[1]s t on (t.%[2]s >= w.week and t.%[2]s < w.week + interval '1 week')
+		group by w.week
+		order by w.week
+	`, table, column)
+
+	rows, err := m.DB.QueryContext(ctx, query, start, weeks)
+	if err != nil {
+		return counts, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.WeekCount
+		if err := rows.Scan(&c.Week, &c.Count); err != nil {
+			return counts, err
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
+
+//DashboardStats gathers the admin dashboard numbers. The weekly series cover weeks weeks,
+//the last one starting on weekStart
+func (m *postgresDBRepo) DashboardStats(weekStart time.Time, weeks int, now time.Time) (models.DashboardStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	defer cancel()
+
+	stats := models.DashboardStats{RateDays: dashboardRateDays}
+
+	err := m.DB.QueryRowContext(ctx, `
+		select count(*) filter (where created_at >= $1),
+		count(*) filter (where created_at >= $1 - interval '1 week' and created_at < $1)
+		from reservations
+		where created_at >= $1 - interval '1 week'
+	`, weekStart).Scan(&stats.NewReservationsThisWeek, &stats.NewReservationsLastWeek)
+	if err != nil {
+		return stats, err
+	}
+
+	var completed, noShows int
+	err = m.DB.QueryRowContext(ctx, `
+		select count(*), count(*) filter (where status = $3), count(*) filter (where status = $4)
+		from reservations
+		where start_time >= $1 and start_time < $2
+		and status not in ('cancelled_by_user', 'cancelled_by_staff')
+	`, now.AddDate(0, 0, -dashboardRateDays), now, models.ReservationCompleted, models.ReservationNoShow).Scan(
+		&stats.SessionsDue, &completed, &noShows)
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.SessionsDue > 0 {
+		stats.CompletionRate = float64(completed) / float64(stats.SessionsDue)
+		stats.NoShowRate = float64(noShows) / float64(stats.SessionsDue)
+	}
+
+	err = m.DB.QueryRowContext(ctx, `
+		select (select count(*) from newsletter_subscribers where status = 'confirmed'),
+		(select count(*) from users)
+	`).Scan(&stats.Subscribers, &stats.Users)
+	if err != nil {
+		return stats, err
+	}
+
+	first := weekStart.AddDate(0, 0, -7*(weeks-1))
+
+	stats.ReservationsPerWeek, err = m.weekCounts(ctx, "reservations", "created_at", first, weeks)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.SubscribersPerWeek, err = m.weekCounts(ctx, "newsletter_subscribers", "confirmed_at", first, weeks)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.RegistrationsPerWeek, err = m.weekCounts(ctx, "users", "created_at", first, weeks)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select content_type, content_id, sum(views)
+		from content_views
+		where day >= $1
+		group by content_type, content_id
+		order by sum(views) desc, content_type, content_id
+		limit 10
+	`, first.Format("2006-01-02"))
+	if err != nil {
+		return stats, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ContentViews
+		if err := rows.Scan(&c.ContentType, &c.ContentID, &c.Views); err != nil {
+			return stats, err
+		}
+		stats.TopContent = append(stats.TopContent, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+//reservationAnswers returns a reservation's intake answers in the order they were asked
+func (m *postgresDBRepo) reservationAnswers(ctx context.Context, reservationID int) ([]models.IntakeAnswer, error) {
+	var answers []models.IntakeAnswer
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select id, reservation_id, coalesce(question_id, 0), question_key, question_label, answer
+		from reservation_answers
+		where reservation_id = $1
+		order by id
+	`, reservationID)
+	if err != nil {
+		return answers, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.IntakeAnswer
+		err := rows.Scan(&a.ID, &a.ReservationID, &a.QuestionID, &a.QuestionKey, &a.QuestionLabel, &a.Answer)
+		if err != nil {
+			return answers, err
+		}
+		answers = append(answers, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return answers, err
+	}
+
+	return answers, nil
+}
+
+//intakeQuestionColumns are the intake_questions columns read by scanIntakeQuestion
+const intakeQuestionColumns = `id, key, label, kind, options, required, position, active, created_at, updated_at`
+
+//scanIntakeQuestion scans one intake_questions row selected with intakeQuestionColumns
+func scanIntakeQuestion(row interface{ Scan(...interface{}) error }) (models.IntakeQuestion, error) {
+	var q models.IntakeQuestion
+	var options string
+
+	err := row.Scan(
+		&q.ID,
+		&q.Key,
+		&q.Label,
+		&q.Kind,
+		&options,
+		&q.Required,
+		&q.Position,
+		&q.Active,
+		&q.CreatedAt,
+		&q.UpdatedAt,
+	)
+	if err != nil {
+		return q, err
+	}
+
+	q.Options = splitList(options)
+
+	return q, nil
+}
+
+//IntakeQuestions returns the intake questions in the order they are asked
+func (m *postgresDBRepo) IntakeQuestions(activeOnly bool) ([]models.IntakeQuestion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var questions []models.IntakeQuestion
+
+	query := `select ` + intakeQuestionColumns + ` from intake_questions
+		where ($1 = false or active = true)
+		order by position, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, activeOnly)
+	if err != nil {
+		return questions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		q, err := scanIntakeQuestion(rows)
+		if err != nil {
+			return questions, err
+		}
+		questions = append(questions, q)
+	}
+
+	if err = rows.Err(); err != nil {
+		return questions, err
+	}
+
+	return questions, nil
+}
+
+//GetIntakeQuestionByID returns one intake question
+func (m *postgresDBRepo) GetIntakeQuestionByID(id int) (models.IntakeQuestion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `select ` + intakeQuestionColumns + ` from intake_questions where id = $1`
+
+	return scanIntakeQuestion(m.DB.QueryRowContext(ctx, query, id))
+}
+
+//InsertIntakeQuestion adds a question to the intake form
+func (m *postgresDBRepo) InsertIntakeQuestion(q models.IntakeQuestion) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into intake_questions (key, label, kind, options, required, position, active,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		q.Key,
+		q.Label,
+		q.Kind,
+		strings.Join(q.Options, ","),
+		q.Required,
+		q.Position,
+		q.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, helpers.NewConflict("intake question", q.Key)
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//UpdateIntakeQuestion changes an intake question. Answers already given keep the old label
+func (m *postgresDBRepo) UpdateIntakeQuestion(q models.IntakeQuestion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update intake_questions set key = $1, label = $2, kind = $3, options = $4,
+		required = $5, position = $6, active = $7, updated_at = $8
+		where id = $9`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		q.Key,
+		q.Label,
+		q.Kind,
+		strings.Join(q.Options, ","),
+		q.Required,
+		q.Position,
+		q.Active,
+		time.Now(),
+		q.ID,
+	)
+
+	if isUniqueViolation(err) {
+		return helpers.NewConflict("intake question", q.Key)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//SessionNotes returns the notes on a reservation, oldest first. The notes are still encrypted
+func (m *postgresDBRepo) SessionNotes(reservationID int) ([]models.SessionNote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var notes []models.SessionNote
+
+	query := `
+		select n.id, n.reservation_id, coalesce(n.author_id, 0),
+		coalesce(trim(concat(u.first_name, ' ', u.last_name)), ''),
+		n.key_version, n.ciphertext, n.created_at, n.updated_at
+		from session_notes n
+		left join users u on (u.id = n.author_id)
+		where n.reservation_id = $1
+		order by n.created_at, n.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return notes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.SessionNote
+		err := rows.Scan(
+			&n.ID,
+			&n.ReservationID,
+			&n.AuthorID,
+			&n.AuthorName,
+			&n.KeyVersion,
+			&n.Ciphertext,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+		)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+
+	return notes, nil
+}
+
+//InsertSessionNote stores an encrypted note on a reservation
+func (m *postgresDBRepo) InsertSessionNote(n models.SessionNote) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var authorID interface{}
+	if n.AuthorID > 0 {
+		authorID = n.AuthorID
+	}
+
+	var id int
+
+	stmt := `insert into session_notes (reservation_id, author_id, key_version, ciphertext, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		n.ReservationID,
+		authorID,
+		n.KeyVersion,
+		n.Ciphertext,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+//SessionNotesToRotate returns up to limit notes after afterID not encrypted with the given key version
+func (m *postgresDBRepo) SessionNotesToRotate(keyVersion, afterID, limit int) ([]models.SessionNote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var notes []models.SessionNote
+
+	query := `
+		select id, reservation_id, key_version, ciphertext
+		from session_notes
+		where key_version <> $1 and id > $2
+		order by id
+		limit $3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, keyVersion, afterID, limit)
+	if err != nil {
+		return notes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.SessionNote
+		err := rows.Scan(&n.ID, &n.ReservationID, &n.KeyVersion, &n.Ciphertext)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+
+	return notes, nil
+}
+
+//UpdateSessionNoteCiphertext replaces a note's ciphertext after it was encrypted again with a
+//newer key. The note is only changed if it still has the key version it was read with
+func (m *postgresDBRepo) UpdateSessionNoteCiphertext(id, fromVersion, toVersion int, ciphertext []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update session_notes set key_version = $1, ciphertext = $2
+		where id = $3 and key_version = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, toVersion, ciphertext, id, fromVersion)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//CounselorWorkloads counts each counselor's requested, confirmed and rescheduled sessions from from on
+func (m *postgresDBRepo) CounselorWorkloads(from time.Time) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	workloads := make(map[int]int)
+
+	query := `
+		select counseling_session_id, count(*)
+		from reservations
+		where start_time >= $1 and status in ('requested', 'confirmed', 'rescheduled')
+		group by counseling_session_id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, from)
+	if err != nil {
+		return workloads, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return workloads, err
+		}
+		workloads[id] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return workloads, err
+	}
+
+	return workloads, nil
+}
+
+//ReassignReservation moves a reservation and the time it blocks to another counselor. It returns a
+//Conflict when the new counselor is already booked at that time
+func (m *postgresDBRepo) ReassignReservation(id, counselingSessionID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		update reservations set counseling_session_id = $1, updated_at = $2
+		where id = $3
+	`, counselingSessionID, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return helpers.NewNotFound("reservation", strconv.Itoa(id))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update counseling_time_restrictions set counseling_session_id = $1, updated_at = $2
+		where reservation_id = $3
+	`, counselingSessionID, time.Now(), id)
+
+	if isExclusionViolation(err) {
+		return helpers.NewConflict("counseling slot", strconv.Itoa(id))
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//NextInviteSequence raises a reservation's calendar invite sequence and returns it. Each invite sent
+//for a reservation needs a higher sequence than the last so calendars replace the earlier one
+func (m *postgresDBRepo) NextInviteSequence(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	var sequence int
+
+	err := m.DB.QueryRowContext(ctx, `
+		update reservations set ics_sequence = ics_sequence + 1
+		where id = $1
+		returning ics_sequence
+	`, id).Scan(&sequence)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, helpers.NewNotFound("reservation", strconv.Itoa(id))
+	} else if err != nil {
+		return 0, err
+	}
+
+	return sequence, nil
 }
@@ -0,0 +1,112 @@
+package dbrepo
+
+import (
+	"server/everydaymuslimappserver/internal/models"
+	"sort"
+	"time"
+)
+
+//timeRange is a period of time that is already taken
+type timeRange struct {
+	start time.Time
+	end   time.Time
+}
+
+//localClock returns t with the same clock time in time.Local. Timestamps without a time zone are
+//scanned as UTC, so they have to be moved back before being compared with local times
+func localClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+//freeSlots splits start to end into back to back slots of length, leaving out slots that overlap a busy time.
+//A slot that would overlap is moved to start when the busy time ends
+func freeSlots(c models.CounselingSession, start, end time.Time, length time.Duration, busy []timeRange) []models.Slot {
+	var slots []models.Slot
+
+	if length <= 0 {
+		return slots
+	}
+
+	sort.Slice(busy, func(i, j int) bool { return busy[i].start.Before(busy[j].start) })
+
+	t := start
+	for !t.Add(length).After(end) {
+		slotEnd := t.Add(length)
+
+		overlap := false
+		for _, b := range busy {
+			if b.start.Before(slotEnd) && b.end.After(t) {
+				overlap = true
+				t = b.end
+				break
+			}
+		}
+
+		if overlap {
+			continue
+		}
+
+		slots = append(slots, models.Slot{
+			CounselingSessionID: c.ID,
+			CounselorName:       c.CounselorName,
+			StartTime:           t,
+			EndTime:             slotEnd,
+		})
+		t = slotEnd
+	}
+
+	return slots
+}
+
+//workingTimes returns when a counselor works on the day of date: the hours of a schedule exception
+//covering that day if there is one, otherwise their weekly windows for that weekday
+func workingTimes(date time.Time, windows []models.AvailabilityWindow, exceptions []models.ScheduleException) []timeRange {
+	var times []timeRange
+
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	at := func(minute int) time.Time {
+		return day.Add(time.Duration(minute) * time.Minute)
+	}
+	sameDay := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, date.Location())
+	}
+
+	for _, e := range exceptions {
+		if day.Before(sameDay(e.StartDate)) || day.After(sameDay(e.EndDate)) {
+			continue
+		}
+		if !e.Closed && e.EndMinute > e.StartMinute {
+			times = append(times, timeRange{start: at(e.StartMinute), end: at(e.EndMinute)})
+		}
+		return times
+	}
+
+	for _, w := range windows {
+		if w.Weekday == day.Weekday() && w.EndMinute > w.StartMinute {
+			times = append(times, timeRange{start: at(w.StartMinute), end: at(w.EndMinute)})
+		}
+	}
+
+	return times
+}
+
+//availableSlots returns a counselor's free slots between start and end, inside the times they work that day
+func availableSlots(c models.CounselingSession, start, end time.Time, length time.Duration,
+	windows []models.AvailabilityWindow, exceptions []models.ScheduleException, busy []timeRange) []models.Slot {
+	var slots []models.Slot
+
+	for _, w := range workingTimes(start, windows, exceptions) {
+		from, to := w.start, w.end
+		if start.After(from) {
+			from = start
+		}
+		if end.Before(to) {
+			to = end
+		}
+		if to.After(from) {
+			slots = append(slots, freeSlots(c, from, to, length, busy)...)
+		}
+	}
+
+	return slots
+}
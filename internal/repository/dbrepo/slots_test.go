@@ -0,0 +1,101 @@
+package dbrepo
+
+import (
+	"server/everydaymuslimappserver/internal/models"
+	"testing"
+	"time"
+)
+
+func TestFreeSlots(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2021, 5, 3, hour, minute, 0, 0, time.UTC)
+	}
+
+	busy := []timeRange{
+		{start: day(13, 0), end: day(13, 30)},
+		{start: day(10, 0), end: day(11, 0)},
+	}
+
+	slots := freeSlots(models.CounselingSession{ID: 1, CounselorName: "Session1"}, day(9, 0), day(15, 0), time.Hour, busy)
+
+	expected := []time.Time{day(9, 0), day(11, 0), day(12, 0), day(13, 30)}
+	if len(slots) != len(expected) {
+		t.Fatalf("expected %d slots but got %d: %v", len(expected), len(slots), slots)
+	}
+
+	for i, s := range slots {
+		if !s.StartTime.Equal(expected[i]) || !s.EndTime.Equal(expected[i].Add(time.Hour)) {
+			t.Errorf("slot %d: expected %s but got %s to %s", i, expected[i], s.StartTime, s.EndTime)
+		}
+		if s.CounselingSessionID != 1 {
+			t.Errorf("slot %d has the wrong counselor", i)
+		}
+	}
+
+	if len(freeSlots(models.CounselingSession{}, day(9, 0), day(9, 30), time.Hour, nil)) != 0 {
+		t.Error("a range shorter than the session length should have no slots")
+	}
+}
+
+func TestAvailableSlots(t *testing.T) {
+	monday := time.Date(2021, 5, 17, 0, 0, 0, 0, time.UTC)
+	at := func(day time.Time, hour int) time.Time {
+		return day.Add(time.Duration(hour) * time.Hour)
+	}
+
+	//Mondays and Thursdays from 9:00 to 12:00 and 14:00 to 16:00
+	var windows []models.AvailabilityWindow
+	for _, day := range []time.Weekday{time.Monday, time.Thursday} {
+		windows = append(windows,
+			models.AvailabilityWindow{Weekday: day, StartMinute: 9 * 60, EndMinute: 12 * 60},
+			models.AvailabilityWindow{Weekday: day, StartMinute: 14 * 60, EndMinute: 16 * 60},
+		)
+	}
+
+	ramadan := time.Date(2021, 4, 13, 0, 0, 0, 0, time.UTC)
+	eid := time.Date(2021, 5, 13, 0, 0, 0, 0, time.UTC)
+	exceptions := []models.ScheduleException{
+		{StartDate: ramadan, EndDate: ramadan.AddDate(0, 0, 29), StartMinute: 20 * 60, EndMinute: 22 * 60},
+		{StartDate: eid, EndDate: eid, Closed: true},
+	}
+
+	var tests = []struct {
+		name     string
+		day      time.Time
+		expected int
+	}{
+		{"weekly windows", monday, 5},
+		{"no window on tuesday", monday.AddDate(0, 0, 1), 0},
+		{"ramadan hours on a monday", time.Date(2021, 4, 19, 0, 0, 0, 0, time.UTC), 2},
+		{"closed for eid on a thursday", eid, 0},
+	}
+
+	for _, tt := range tests {
+		slots := availableSlots(models.CounselingSession{ID: 1}, at(tt.day, 0), at(tt.day, 24), time.Hour, windows, exceptions, nil)
+		if len(slots) != tt.expected {
+			t.Errorf("%s: expected %d slots but got %d", tt.name, tt.expected, len(slots))
+		}
+	}
+
+	busy := []timeRange{{start: at(monday, 10), end: at(monday, 11)}}
+	slots := availableSlots(models.CounselingSession{ID: 1}, at(monday, 10), at(monday, 15), time.Hour, windows, nil, busy)
+	if len(slots) != 2 || !slots[0].StartTime.Equal(at(monday, 11)) || !slots[1].StartTime.Equal(at(monday, 14)) {
+		t.Errorf("expected slots at 11:00 and 14:00 but got %v", slots)
+	}
+}
+
+func TestLocalClock(t *testing.T) {
+	scanned := time.Date(2021, 5, 3, 12, 30, 0, 0, time.UTC)
+
+	got := localClock(scanned)
+	if got.Location() != time.Local || got.Hour() != 12 || got.Minute() != 30 || got.Day() != 3 {
+		t.Errorf("expected 12:30 on the 3rd in local time but got %s", got)
+	}
+
+	start := time.Date(2021, 5, 3, 12, 0, 0, 0, time.Local)
+	slots := freeSlots(models.CounselingSession{ID: 1}, start, start.Add(2*time.Hour), time.Hour,
+		[]timeRange{{start: localClock(scanned), end: localClock(scanned.Add(time.Hour))}})
+	if len(slots) != 0 {
+		t.Errorf("expected the busy time to block both slots but got %v", slots)
+	}
+}
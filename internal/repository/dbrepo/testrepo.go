@@ -1,27 +1,85 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"server/everydaymuslimappserver/internal/helpers"
 	"server/everydaymuslimappserver/internal/models"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (m *testDBRepo) AllUsers() bool {
 	return true
 }
 
-//InsertReservation inserts a reservation to the DB
+//InsertReservation inserts a reservation to the DB. The 16:00 slot is always just taken
 func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 
 	if res.CounselingSessionID == 2 {
 		return 0, errors.New("An error occurred")
 	}
+	if res.StartTime.Hour() == 16 {
+		return 0, helpers.NewConflict("counseling slot", res.StartTime.Format("2006-01-02 15:04"))
+	}
 	return 1, nil
 }
 
 func (m *testDBRepo) InsertCounselingTimeRestriction(r models.CounselingSessionTimeRestriction) error {
-	if r.Restriction.CounselingSessionID == 200_000 {
+	if r.CounselingSessionID == 200_000 {
 		return errors.New("An error occurred")
 	}
+	if r.StartTime.Hour() == 16 {
+		return helpers.NewConflict("counseling slot", r.StartTime.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+//CounselingTimeRestrictions returns a booking and an owner block on 3 May 2021
+func (m *testDBRepo) CounselingTimeRestrictions(start, end time.Time, counselingSessionID int) ([]models.CounselingSessionTimeRestriction, error) {
+	if counselingSessionID == 200_000 {
+		return nil, errors.New("An error occurred")
+	}
+
+	day := time.Date(2021, 5, 3, 0, 0, 0, 0, time.Local)
+	restrictions := []models.CounselingSessionTimeRestriction{
+		{
+			ID:                  1,
+			StartTime:           day.Add(14 * time.Hour),
+			EndTime:             day.Add(15 * time.Hour),
+			CounselingSessionID: 1,
+			ReservationID:       1,
+			RestrictionID:       models.RestrictionReservation,
+			Session:             testCounselor(1),
+			Reservation:         models.Reservation{ID: 1, FirstName: "Maryam", LastName: "Yusuf", Status: models.ReservationConfirmed},
+		},
+		{
+			ID:                  2,
+			StartTime:           day.Add(16 * time.Hour),
+			EndTime:             day.Add(18 * time.Hour),
+			CounselingSessionID: 1,
+			RestrictionID:       models.RestrictionOwnerBlock,
+			Session:             testCounselor(1),
+		},
+	}
+
+	var found []models.CounselingSessionTimeRestriction
+	for _, r := range restrictions {
+		if r.StartTime.Before(end) && r.EndTime.After(start) {
+			found = append(found, r)
+		}
+	}
+
+	return found, nil
+}
+
+//DeleteOwnerBlock removes time an admin blocked out
+func (m *testDBRepo) DeleteOwnerBlock(id int) error {
+	if id > 100 {
+		return helpers.NewNotFound("owner block", strconv.Itoa(id))
+	}
 	return nil
 }
 
@@ -34,26 +92,109 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 	return 1, "", nil
 }
 
-//AllReservations returns a slice of all reservations
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-
-	var reservations []models.Reservation
-
-	return reservations, nil
+//FilterReservations pages through 30 reservations, one a day from 1 May 2021.
+//Every third one is confirmed and the rest are requested
+func (m *testDBRepo) FilterReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	if f.CounselingSessionID == 200_000 {
+		return nil, 0, errors.New("An error occurred")
+	}
 
-}
+	var found []models.Reservation
+	for id := 1; id <= 30; id++ {
+		start := time.Date(2021, 5, id, 10, 0, 0, 0, time.Local)
+		res := models.Reservation{
+			ID:                  id,
+			FirstName:           "Client",
+			LastName:            strconv.Itoa(id),
+			Email:               fmt.Sprintf("client%d@example.com", id),
+			StartTime:           start,
+			EndTime:             start.Add(time.Hour),
+			Date:                start,
+			Status:              models.ReservationRequested,
+			CounselingSessionID: 1,
+			CounselingSession:   testCounselor(1),
+		}
+		if id%3 == 0 {
+			res.Status = models.ReservationConfirmed
+		}
 
-//AllNewReservations returns a slice of all new reservations
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+		switch {
+		case f.Status != "" && res.Status != f.Status:
+		case f.CounselingSessionID > 0 && res.CounselingSessionID != f.CounselingSessionID:
+		case !f.From.IsZero() && start.Before(f.From):
+		case !f.To.IsZero() && !start.Before(f.To):
+		case f.Search != "" && !strings.Contains(strings.ToLower(res.FirstName+" "+res.LastName+" "+res.Email), strings.ToLower(f.Search)):
+		default:
+			found = append(found, res)
+		}
+	}
 
-	var reservations []models.Reservation
+	if f.Desc {
+		for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+			found[i], found[j] = found[j], found[i]
+		}
+	}
 
-	return reservations, nil
+	total := len(found)
+	if f.Offset >= total {
+		return nil, 0, nil
+	}
+	found = found[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(found) {
+		found = found[:f.Limit]
+	}
 
+	return found, total, nil
 }
 
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var reservations models.Reservation
+	if id > 100 {
+		return reservations, sql.ErrNoRows
+	}
+	reservations.ID = id
+	reservations.FirstName = "Maryam"
+	reservations.LastName = "Yusuf"
+	reservations.Email = "maryam@example.com"
+	reservations.Date = time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC)
+	reservations.StartTime = time.Date(2021, 5, 3, 14, 0, 0, 0, time.UTC)
+	reservations.EndTime = time.Date(2021, 5, 3, 15, 0, 0, 0, time.UTC)
+	reservations.CounselingSession.CounselorName = "Session1"
+	reservations.MeetingLink = "https://meet.example.com/session"
+	reservations.CounselingSessionID = 1
+	reservations.CounselingSession.UserID = 2
+	reservations.Status = models.ReservationRequested
+	reservations.Gender = "female"
+	reservations.Answers = []models.IntakeAnswer{
+		{ReservationID: id, QuestionID: 1, QuestionKey: models.IntakeTopic, QuestionLabel: "What would you like to talk about?", Answer: "Family"},
+		{ReservationID: id, QuestionID: 3, QuestionKey: models.IntakeLanguage, QuestionLabel: "Which language would you prefer?", Answer: "English"},
+		{ReservationID: id, QuestionID: 4, QuestionKey: models.IntakeSameGender, QuestionLabel: "Would you prefer a counselor of the same gender?", Answer: "yes"},
+	}
+	if id == 2 {
+		reservations.Status = models.ReservationCompleted
+	}
+	if id == 3 {
+		//A confirmed session three days from now, which the client can still change
+		start := time.Now().Add(72 * time.Hour).Truncate(time.Hour)
+		reservations.Date = start
+		reservations.StartTime = start
+		reservations.EndTime = start.Add(time.Hour)
+		reservations.Status = models.ReservationConfirmed
+	}
+	if id == 100 {
+		//Its emails fail, and it is far enough away for the client to change it
+		start := time.Now().Add(72 * time.Hour).Truncate(time.Hour)
+		reservations.Date = start
+		reservations.StartTime = start
+		reservations.EndTime = start.Add(time.Hour)
+	}
+	if id == 8 {
+		//Booked with a counselor who has since left, so it needs another one
+		reservations.CounselingSessionID = 5
+		reservations.CounselingSession.ID = 5
+		reservations.CounselingSession.CounselorName = "Former Counselor"
+		reservations.CounselingSession.UserID = 0
+	}
 	return reservations, nil
 }
 
@@ -66,6 +207,647 @@ func (m *testDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+//TransitionReservation moves a reservation to a new status. Nothing can be moved to 16:00
+func (m *testDBRepo) TransitionReservation(c models.ReservationStatusChange) error {
+	if c.StartTime.Hour() == 16 {
+		return helpers.NewConflict("counseling slot", c.StartTime.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+//DueReminders returns a reminder for each offset for a session booked from Karachi
+func (m *testDBRepo) DueReminders(offsets []time.Duration, now time.Time) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	for _, offset := range offsets {
+		res, _ := m.GetReservationByID(1)
+		res.Status = models.ReservationConfirmed
+		res.Timezone = "Asia/Karachi"
+		reminders = append(reminders, models.Reminder{Reservation: res, Offset: offset})
+	}
+	return reminders, nil
+}
+
+//QueueReminder adds a reminder email to the outbox
+func (m *testDBRepo) QueueReminder(rem models.Reminder, mail models.MailData) (bool, error) {
+	_, err := m.InsertOutboxEmail(mail)
+	return err == nil, err
+}
+
+//ReservationStatusChanges returns the status history of a reservation
+func (m *testDBRepo) ReservationStatusChanges(reservationID int) ([]models.ReservationStatusChange, error) {
+	return []models.ReservationStatusChange{{
+		ID:            1,
+		ReservationID: reservationID,
+		ToStatus:      models.ReservationRequested,
+		StartTime:     time.Date(2021, 5, 3, 14, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2021, 5, 3, 15, 0, 0, 0, time.UTC),
+		CreatedAt:     time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC),
+	}}, nil
+}
+
+func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+	var u models.User
+	if id == 0 {
+		return u, errors.New("user not found")
+	}
+	u.ID = id
+	u.Email = "admin@example.com"
+	if id == 1 {
+		u.AccessLevel = models.AccessLevelAdmin
+	}
+	return u, nil
+}
+
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	var u models.User
+	if email == "notfound@example.com" {
+		return u, sql.ErrNoRows
+	}
+	u.ID = 1
+	u.Email = email
+	return u, nil
+}
+
+//GetReservationsByEmail returns every reservation made with an email address
+func (m *testDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+//AnonymizePersonalData removes a person's data from the DB
+func (m *testDBRepo) AnonymizePersonalData(email string) error {
+	return nil
+}
+
+//InsertAuditEvent records an admin or auth action in the audit log
+func (m *testDBRepo) InsertAuditEvent(e models.AuditEvent) error {
+	return nil
+}
+
+//AuditEvents returns the audit events matching the filter
+func (m *testDBRepo) AuditEvents(f models.AuditFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	if f.ActorID == 1 {
+		events = append(events, models.AuditEvent{
+			ID:        1,
+			ActorID:   1,
+			Action:    "auth.login",
+			Target:    "user:1",
+			IPAddress: "192.0.2.1",
+			UserAgent: "Mozilla/5.0",
+			CreatedAt: time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC),
+		})
+	}
+	return events, nil
+}
+
+//GetNewsletterSubscriberByID gets a newsletter subscriber by ID
+func (m *testDBRepo) GetNewsletterSubscriberByID(id int) (models.NewsletterSubscriber, error) {
+	var s models.NewsletterSubscriber
+	if id == 0 {
+		return s, sql.ErrNoRows
+	}
+	s.ID = id
+	s.Email = "subscribed@example.com"
+	s.Status = models.SubscriberConfirmed
+	return s, nil
+}
+
+//GetNewsletterSubscriberByEmail gets a newsletter subscriber by email
+func (m *testDBRepo) GetNewsletterSubscriberByEmail(email string) (models.NewsletterSubscriber, error) {
+	var s models.NewsletterSubscriber
+	if email == "subscribed@example.com" {
+		s.ID = 1
+		s.Email = email
+		s.Status = models.SubscriberConfirmed
+		return s, nil
+	}
+	return s, sql.ErrNoRows
+}
+
+//InsertNewsletterSubscriber inserts a newsletter subscriber into the DB
+func (m *testDBRepo) InsertNewsletterSubscriber(s models.NewsletterSubscriber) (int, error) {
+	return 1, nil
+}
+
+//UpdateNewsletterSubscriber updates a newsletter subscriber in the DB
+func (m *testDBRepo) UpdateNewsletterSubscriber(s models.NewsletterSubscriber) error {
+	return nil
+}
+
+//ConfirmNewsletterSubscriber confirms the subscriber holding a confirmation token
+func (m *testDBRepo) ConfirmNewsletterSubscriber(token string) (models.NewsletterSubscriber, error) {
+	var s models.NewsletterSubscriber
+	if token == "invalid" {
+		return s, helpers.NewNotFound("confirmation token", token)
+	}
+	s.ID = 1
+	s.Status = models.SubscriberConfirmed
+	return s, nil
+}
+
+//AllCampaigns returns every newsletter campaign
+func (m *testDBRepo) AllCampaigns() ([]models.Campaign, error) {
+	var campaigns []models.Campaign
+	return campaigns, nil
+}
+
+//GetCampaignByID gets a newsletter campaign by ID
+func (m *testDBRepo) GetCampaignByID(id int) (models.Campaign, error) {
+	var c models.Campaign
+	if id > 100 {
+		return c, sql.ErrNoRows
+	}
+	c.ID = id
+	c.Subject = "Test campaign"
+	c.Body = "<p>Assalamu alaikum</p>"
+	c.Audience = "all"
+	c.Status = models.CampaignDraft
+	return c, nil
+}
+
+//InsertCampaign inserts a newsletter campaign into the DB
+func (m *testDBRepo) InsertCampaign(c models.Campaign) (int, error) {
+	return 1, nil
+}
+
+//UpdateCampaign updates a newsletter campaign in the DB
+func (m *testDBRepo) UpdateCampaign(c models.Campaign) error {
+	return nil
+}
+
+//DueCampaigns returns campaigns that should be sent now
+func (m *testDBRepo) DueCampaigns(now time.Time) ([]models.Campaign, error) {
+	var campaigns []models.Campaign
+	return campaigns, nil
+}
+
+//StartCampaign marks a campaign as sending
+func (m *testDBRepo) StartCampaign(id int) error {
+	return nil
+}
+
+//FinishCampaign marks a campaign as sent
+func (m *testDBRepo) FinishCampaign(id int) error {
+	return nil
+}
+
+//PendingCampaignRecipients returns recipients who have not been sent a campaign yet
+func (m *testDBRepo) PendingCampaignRecipients(campaignID, limit int) ([]models.CampaignRecipient, error) {
+	var recipients []models.CampaignRecipient
+	return recipients, nil
+}
+
+//UpdateCampaignRecipientStatus records the delivery status for one campaign recipient
+func (m *testDBRepo) UpdateCampaignRecipientStatus(id int, status, errorMessage string) error {
+	return nil
+}
+
+//QueueCampaignEmail adds a campaign email to the outbox and marks its recipient as queued
+func (m *testDBRepo) QueueCampaignEmail(recipientID int, mail models.MailData) error {
+	_, err := m.InsertOutboxEmail(mail)
+	return err
+}
+
+//CampaignRecipientCounts returns the number of recipients of a campaign in each status
+func (m *testDBRepo) CampaignRecipientCounts(campaignID int) (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+//FilterNewsletterSubscribers returns the newsletter subscribers matching the filter
+func (m *testDBRepo) FilterNewsletterSubscribers(f models.SubscriberFilter) ([]models.NewsletterSubscriber, error) {
+	var subscribers []models.NewsletterSubscriber
+	subscribers = append(subscribers, models.NewsletterSubscriber{
+		ID:     1,
+		Email:  "subscribed@example.com",
+		Status: models.SubscriberConfirmed,
+		Tags:   []string{"Ramadan reminders"},
+	})
+	return subscribers, nil
+}
+
+//ImportNewsletterSubscribers adds confirmed subscribers, skipping addresses that already exist
+func (m *testDBRepo) ImportNewsletterSubscribers(subscribers []models.NewsletterSubscriber, tagID int) (int, error) {
+	return len(subscribers), nil
+}
+
+//SubscriberTags returns every subscriber tag
+func (m *testDBRepo) SubscriberTags() ([]models.SubscriberTag, error) {
+	var tags []models.SubscriberTag
+	tags = append(tags, models.SubscriberTag{ID: 1, Name: "Ramadan reminders", Subscribers: 1})
+	return tags, nil
+}
+
+//InsertSubscriberTag inserts a subscriber tag into the DB
+func (m *testDBRepo) InsertSubscriberTag(name string) (int, error) {
+	return 1, nil
+}
+
+//TagNewsletterSubscribers adds a tag to newsletter subscribers
+func (m *testDBRepo) TagNewsletterSubscribers(tagID int, subscriberIDs []int) error {
+	return nil
+}
+
+//UntagNewsletterSubscribers removes a tag from newsletter subscribers
+func (m *testDBRepo) UntagNewsletterSubscribers(tagID int, subscriberIDs []int) error {
+	return nil
+}
+
+//InsertOutboxEmail adds an email to the outbox to be sent by the mail workers
+func (m *testDBRepo) InsertOutboxEmail(mail models.MailData) (int, error) {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	id := len(m.outbox.emails) + 1
+	m.outbox.emails = append(m.outbox.emails, models.OutboxEmail{
+		ID:            id,
+		Mail:          mail,
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	})
+	return id, nil
+}
+
+//ClaimOutboxEmails locks up to limit emails that are due to be sent and marks them as sending
+func (m *testDBRepo) ClaimOutboxEmails(limit int) ([]models.OutboxEmail, error) {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	var claimed []models.OutboxEmail
+	for i := range m.outbox.emails {
+		if len(claimed) >= limit {
+			break
+		}
+		e := &m.outbox.emails[i]
+		if e.Status == models.OutboxPending && !e.NextAttemptAt.After(time.Now()) {
+			e.Status = models.OutboxSending
+			e.Attempts++
+			claimed = append(claimed, *e)
+		}
+	}
+	return claimed, nil
+}
+
+//MarkOutboxEmailSent records that an outbox email was sent
+func (m *testDBRepo) MarkOutboxEmailSent(id int) error {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	if id < 1 || id > len(m.outbox.emails) {
+		return sql.ErrNoRows
+	}
+	m.outbox.emails[id-1].Status = models.OutboxSent
+	m.outbox.emails[id-1].SentAt = time.Now()
+	return nil
+}
+
+//MarkOutboxEmailFailed records a failed attempt
+func (m *testDBRepo) MarkOutboxEmailFailed(id int, errorMessage string, nextAttempt time.Time, dead bool) error {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	if id < 1 || id > len(m.outbox.emails) {
+		return sql.ErrNoRows
+	}
+	e := &m.outbox.emails[id-1]
+	e.Status = models.OutboxPending
+	if dead {
+		e.Status = models.OutboxDead
+	}
+	e.LastError = errorMessage
+	e.NextAttemptAt = nextAttempt
+	return nil
+}
+
+//FilterOutboxEmails returns the newest outbox emails, optionally only those in one status
+func (m *testDBRepo) FilterOutboxEmails(status string, limit int) ([]models.OutboxEmail, error) {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	var emails []models.OutboxEmail
+	for i := len(m.outbox.emails) - 1; i >= 0 && len(emails) < limit; i-- {
+		if status == "" || m.outbox.emails[i].Status == status {
+			emails = append(emails, m.outbox.emails[i])
+		}
+	}
+	return emails, nil
+}
+
+//OutboxCounts returns the number of outbox emails in each status
+func (m *testDBRepo) OutboxCounts() (map[string]int, error) {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, e := range m.outbox.emails {
+		counts[e.Status]++
+	}
+	return counts, nil
+}
+
+//RetryOutboxEmail queues a failed or dead outbox email to be sent again straight away
+func (m *testDBRepo) RetryOutboxEmail(id int) error {
+	m.outbox.mu.Lock()
+	defer m.outbox.mu.Unlock()
+
+	if id < 1 || id > len(m.outbox.emails) || m.outbox.emails[id-1].Status == models.OutboxSent {
+		return helpers.NewNotFound("retryable outbox email", strconv.Itoa(id))
+	}
+	e := &m.outbox.emails[id-1]
+	e.Status = models.OutboxPending
+	e.Attempts = 0
+	e.NextAttemptAt = time.Now()
+	return nil
+}
+
+//SearchAvailability returns the free slots of Session1, which is busy from 12:00 to 13:00 every day
+func (m *testDBRepo) SearchAvailability(start, end time.Time, sessionLength time.Duration, counselingSessionID int) ([]models.Slot, error) {
+	if counselingSessionID > 1 {
+		return nil, nil
+	}
+
+	c, _ := m.GetCounselorByID(1)
+	windows, _ := m.CounselorAvailability(1)
+	exceptions, _ := m.CounselorScheduleExceptions(1)
+
+	noon := time.Date(start.Year(), start.Month(), start.Day(), 12, 0, 0, 0, start.Location())
+	busy := []timeRange{{start: noon, end: noon.Add(time.Hour)}}
+
+	return availableSlots(c, start, end, sessionLength, windows, exceptions, busy), nil
+}
+
+//testCounselor is the counselor every counselor stub returns
+func testCounselor(id int) models.CounselingSession {
+	return models.CounselingSession{
+		ID:            id,
+		CounselorName: "Session1",
+		UserID:        1,
+		UserEmail:     "admin@example.com",
+		Bio:           "Counselor for families and young people",
+		Gender:        "female",
+		Languages:     []string{"English", "Arabic"},
+		Specialties:   []string{"family", "youth"},
+		Active:        true,
+	}
+}
+
+//AllCounselors returns every counselor
+func (m *testDBRepo) AllCounselors() ([]models.CounselingSession, error) {
+	return []models.CounselingSession{testCounselor(1)}, nil
+}
+
+//GetCounselorByID returns one counselor
+func (m *testDBRepo) GetCounselorByID(id int) (models.CounselingSession, error) {
+	if id > 100 {
+		return models.CounselingSession{}, sql.ErrNoRows
+	}
+	return testCounselor(id), nil
+}
+
+//GetCounselorByUserID returns the counselor linked to a user account
+func (m *testDBRepo) GetCounselorByUserID(userID int) (models.CounselingSession, error) {
+	if userID != 1 {
+		return models.CounselingSession{}, sql.ErrNoRows
+	}
+	return testCounselor(1), nil
+}
+
+//InsertCounselor inserts a counselor into the DB
+func (m *testDBRepo) InsertCounselor(c models.CounselingSession) (int, error) {
+	return 1, nil
+}
+
+//UpdateCounselor updates a counselor in the DB
+func (m *testDBRepo) UpdateCounselor(c models.CounselingSession) error {
+	return nil
+}
+
+//CounselorAvailability returns Monday to Friday from 9:00 to 17:00
+func (m *testDBRepo) CounselorAvailability(counselingSessionID int) ([]models.AvailabilityWindow, error) {
+	var windows []models.AvailabilityWindow
+	for day := time.Monday; day <= time.Friday; day++ {
+		windows = append(windows, models.AvailabilityWindow{
+			CounselingSessionID: counselingSessionID,
+			Weekday:             day,
+			StartMinute:         9 * 60,
+			EndMinute:           17 * 60,
+		})
+	}
+	return windows, nil
+}
+
+//ReplaceCounselorAvailability replaces all of a counselor's weekly availability windows
+func (m *testDBRepo) ReplaceCounselorAvailability(counselingSessionID int, windows []models.AvailabilityWindow) error {
+	return nil
+}
+
+//CounselorScheduleExceptions returns a closed day for Eid on 2021-05-13
+func (m *testDBRepo) CounselorScheduleExceptions(counselingSessionID int) ([]models.ScheduleException, error) {
+	//The counselor is closed on a Thursday in the coming weeks so searches for it are not in the past
+	now := time.Now()
+	eid := time.Date(now.Year(), now.Month(), now.Day()+7+(int(time.Thursday)-int(now.Weekday())+7)%7, 0, 0, 0, 0, time.UTC)
+	return []models.ScheduleException{{
+		ID:                  1,
+		CounselingSessionID: counselingSessionID,
+		StartDate:           eid,
+		EndDate:             eid,
+		Closed:              true,
+		Note:                "Eid al-Fitr",
+	}}, nil
+}
+
+//InsertScheduleException inserts a schedule exception into the DB
+func (m *testDBRepo) InsertScheduleException(e models.ScheduleException) (int, error) {
+	return 1, nil
+}
+
+//DeleteScheduleException deletes one of a counselor's schedule exceptions
+func (m *testDBRepo) DeleteScheduleException(counselingSessionID, id int) error {
+	if id > 100 {
+		return helpers.NewNotFound("schedule exception", strconv.Itoa(id))
+	}
+	return nil
+}
+
+//RecordContentView counts one view of a hadith, ayah, dua or surah on day
+func (m *testDBRepo) RecordContentView(contentType, contentID string, day time.Time) error {
+	if contentID == "500" {
+		return errors.New("An error occurred")
+	}
+	return nil
+}
+
+//DashboardStats returns made up dashboard numbers with one count per week
+func (m *testDBRepo) DashboardStats(weekStart time.Time, weeks int, now time.Time) (models.DashboardStats, error) {
+	stats := models.DashboardStats{
+		NewReservationsThisWeek: 4,
+		NewReservationsLastWeek: 6,
+		SessionsDue:             20,
+		CompletionRate:          0.75,
+		NoShowRate:              0.1,
+		RateDays:                90,
+		Subscribers:             120,
+		Users:                   35,
+		TopContent: []models.ContentViews{
+			{ContentType: "hadith", ContentID: "1", Views: 42},
+			{ContentType: "surah", ContentID: "36", Views: 17},
+		},
+	}
+
+	for i := weeks - 1; i >= 0; i-- {
+		week := weekStart.AddDate(0, 0, -7*i)
+		stats.ReservationsPerWeek = append(stats.ReservationsPerWeek, models.WeekCount{Week: week, Count: i + 1})
+		stats.SubscribersPerWeek = append(stats.SubscribersPerWeek, models.WeekCount{Week: week, Count: 2 * i})
+		stats.RegistrationsPerWeek = append(stats.RegistrationsPerWeek, models.WeekCount{Week: week, Count: i % 3})
+	}
+
+	return stats, nil
+}
+
+//testIntakeQuestions are the intake questions the migrations seed, plus an inactive one
+func testIntakeQuestions() []models.IntakeQuestion {
+	return []models.IntakeQuestion{
+		{ID: 1, Key: models.IntakeTopic, Label: "What would you like to talk about?", Kind: models.IntakeChoice,
+			Options: []string{"Marriage", "Family", "Grief", "Youth", "Faith", "Other"}, Required: true, Position: 1, Active: true},
+		{ID: 2, Key: models.IntakeUrgency, Label: "How soon do you need support?", Kind: models.IntakeChoice,
+			Options: []string{"Not urgent", "Within a week", "As soon as possible"}, Required: true, Position: 2, Active: true},
+		{ID: 3, Key: models.IntakeLanguage, Label: "Which language would you prefer?", Kind: models.IntakeChoice,
+			Options: []string{"English", "Arabic", "Urdu"}, Required: true, Position: 3, Active: true},
+		{ID: 4, Key: models.IntakeSameGender, Label: "Would you prefer a counselor of the same gender?", Kind: models.IntakeYesNo,
+			Required: true, Position: 4, Active: true},
+		{ID: 5, Key: "reason", Label: "Is there anything else we should know?", Kind: models.IntakeText,
+			Position: 5, Active: true},
+		{ID: 6, Key: "referral", Label: "How did you hear about us?", Kind: models.IntakeText,
+			Position: 6, Active: false},
+	}
+}
+
+//IntakeQuestions returns the intake questions in the order they are asked
+func (m *testDBRepo) IntakeQuestions(activeOnly bool) ([]models.IntakeQuestion, error) {
+	var questions []models.IntakeQuestion
+	for _, q := range testIntakeQuestions() {
+		if q.Active || !activeOnly {
+			questions = append(questions, q)
+		}
+	}
+	return questions, nil
+}
+
+//GetIntakeQuestionByID returns one intake question
+func (m *testDBRepo) GetIntakeQuestionByID(id int) (models.IntakeQuestion, error) {
+	for _, q := range testIntakeQuestions() {
+		if q.ID == id {
+			return q, nil
+		}
+	}
+	return models.IntakeQuestion{}, sql.ErrNoRows
+}
+
+//InsertIntakeQuestion adds a question to the intake form. The seeded keys are taken
+func (m *testDBRepo) InsertIntakeQuestion(q models.IntakeQuestion) (int, error) {
+	for _, existing := range testIntakeQuestions() {
+		if existing.Key == q.Key {
+			return 0, helpers.NewConflict("intake question", q.Key)
+		}
+	}
+	return 7, nil
+}
+
+//UpdateIntakeQuestion changes an intake question
+func (m *testDBRepo) UpdateIntakeQuestion(q models.IntakeQuestion) error {
+	for _, existing := range testIntakeQuestions() {
+		if existing.Key == q.Key && existing.ID != q.ID {
+			return helpers.NewConflict("intake question", q.Key)
+		}
+	}
 	return nil
 }
+
+//SessionNotes returns the notes on a reservation. Reservation 404 fails
+func (m *testDBRepo) SessionNotes(reservationID int) ([]models.SessionNote, error) {
+	if reservationID == 404 {
+		return nil, errors.New("could not read notes")
+	}
+
+	m.notes.mu.Lock()
+	defer m.notes.mu.Unlock()
+
+	var notes []models.SessionNote
+	for _, n := range m.notes.notes {
+		if n.ReservationID == reservationID {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+//InsertSessionNote stores an encrypted note on a reservation
+func (m *testDBRepo) InsertSessionNote(n models.SessionNote) (int, error) {
+	m.notes.mu.Lock()
+	defer m.notes.mu.Unlock()
+
+	n.ID = len(m.notes.notes) + 1
+	n.CreatedAt = time.Now()
+	n.UpdatedAt = n.CreatedAt
+	m.notes.notes = append(m.notes.notes, n)
+	return n.ID, nil
+}
+
+//SessionNotesToRotate returns up to limit notes after afterID not encrypted with the given key version
+func (m *testDBRepo) SessionNotesToRotate(keyVersion, afterID, limit int) ([]models.SessionNote, error) {
+	m.notes.mu.Lock()
+	defer m.notes.mu.Unlock()
+
+	var notes []models.SessionNote
+	for _, n := range m.notes.notes {
+		if n.KeyVersion != keyVersion && n.ID > afterID && len(notes) < limit {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+//UpdateSessionNoteCiphertext replaces a note's ciphertext if it still has the given key version
+func (m *testDBRepo) UpdateSessionNoteCiphertext(id, fromVersion, toVersion int, ciphertext []byte) error {
+	m.notes.mu.Lock()
+	defer m.notes.mu.Unlock()
+
+	for i, n := range m.notes.notes {
+		if n.ID == id && n.KeyVersion == fromVersion {
+			m.notes.notes[i].KeyVersion = toVersion
+			m.notes.notes[i].Ciphertext = ciphertext
+		}
+	}
+	return nil
+}
+
+//CounselorWorkloads gives Session1 two upcoming sessions
+func (m *testDBRepo) CounselorWorkloads(from time.Time) (map[int]int, error) {
+	return map[int]int{1: 2}, nil
+}
+
+//ReassignReservation moves a reservation to another counselor. Counselor 2 is booked at every time
+func (m *testDBRepo) ReassignReservation(id, counselingSessionID int) error {
+	if id > 100 {
+		return helpers.NewNotFound("reservation", strconv.Itoa(id))
+	}
+	if counselingSessionID == 2 {
+		return helpers.NewConflict("counseling slot", strconv.Itoa(id))
+	}
+	return nil
+}
+
+//NextInviteSequence raises a reservation's calendar invite sequence and returns it
+func (m *testDBRepo) NextInviteSequence(id int) (int, error) {
+	if id == 100 {
+		return 0, errors.New("could not update the invite sequence")
+	}
+
+	m.invite.mu.Lock()
+	defer m.invite.mu.Unlock()
+
+	m.invite.byID[id]++
+	return m.invite.byID[id], nil
+}
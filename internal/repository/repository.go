@@ -1,21 +1,97 @@
 package repository
 
-import "server/everydaymuslimappserver/internal/models"
+import (
+	"server/everydaymuslimappserver/internal/models"
+	"time"
+)
 
 type DatabaseRepo interface {
 	AllUsers() bool
 	UpdateUser(m models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
+	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
 
 	InsertReservation(res models.Reservation) (int, error)
 	InsertCounselingTimeRestriction(r models.CounselingSessionTimeRestriction) error
+	CounselingTimeRestrictions(start, end time.Time, counselingSessionID int) ([]models.CounselingSessionTimeRestriction, error)
+	DeleteOwnerBlock(id int) error
+	CounselorWorkloads(from time.Time) (map[int]int, error)
+	ReassignReservation(id, counselingSessionID int) error
+	NextInviteSequence(id int) (int, error)
+	SearchAvailability(start, end time.Time, sessionLength time.Duration, counselingSessionID int) ([]models.Slot, error)
 
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	IntakeQuestions(activeOnly bool) ([]models.IntakeQuestion, error)
+	GetIntakeQuestionByID(id int) (models.IntakeQuestion, error)
+	InsertIntakeQuestion(q models.IntakeQuestion) (int, error)
+	UpdateIntakeQuestion(q models.IntakeQuestion) error
+
+	SessionNotes(reservationID int) ([]models.SessionNote, error)
+	InsertSessionNote(n models.SessionNote) (int, error)
+	SessionNotesToRotate(keyVersion, afterID, limit int) ([]models.SessionNote, error)
+	UpdateSessionNoteCiphertext(id, fromVersion, toVersion int, ciphertext []byte) error
+
+	AllCounselors() ([]models.CounselingSession, error)
+	GetCounselorByID(id int) (models.CounselingSession, error)
+	GetCounselorByUserID(userID int) (models.CounselingSession, error)
+	InsertCounselor(c models.CounselingSession) (int, error)
+	UpdateCounselor(c models.CounselingSession) error
+	CounselorAvailability(counselingSessionID int) ([]models.AvailabilityWindow, error)
+	ReplaceCounselorAvailability(counselingSessionID int, windows []models.AvailabilityWindow) error
+	CounselorScheduleExceptions(counselingSessionID int) ([]models.ScheduleException, error)
+	InsertScheduleException(e models.ScheduleException) (int, error)
+	DeleteScheduleException(counselingSessionID, id int) error
+
+	FilterReservations(f models.ReservationFilter) ([]models.Reservation, int, error)
 	GetReservationByID(id int) (models.Reservation, error)
 
 	UpdateReservation(u models.Reservation) error
 
 	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	TransitionReservation(c models.ReservationStatusChange) error
+	ReservationStatusChanges(reservationID int) ([]models.ReservationStatusChange, error)
+	DueReminders(offsets []time.Duration, now time.Time) ([]models.Reminder, error)
+	QueueReminder(rem models.Reminder, mail models.MailData) (bool, error)
+
+	GetReservationsByEmail(email string) ([]models.Reservation, error)
+	AnonymizePersonalData(email string) error
+
+	GetNewsletterSubscriberByID(id int) (models.NewsletterSubscriber, error)
+	GetNewsletterSubscriberByEmail(email string) (models.NewsletterSubscriber, error)
+	InsertNewsletterSubscriber(s models.NewsletterSubscriber) (int, error)
+	UpdateNewsletterSubscriber(s models.NewsletterSubscriber) error
+	ConfirmNewsletterSubscriber(token string) (models.NewsletterSubscriber, error)
+	FilterNewsletterSubscribers(f models.SubscriberFilter) ([]models.NewsletterSubscriber, error)
+	ImportNewsletterSubscribers(subscribers []models.NewsletterSubscriber, tagID int) (int, error)
+
+	SubscriberTags() ([]models.SubscriberTag, error)
+	InsertSubscriberTag(name string) (int, error)
+	TagNewsletterSubscribers(tagID int, subscriberIDs []int) error
+	UntagNewsletterSubscribers(tagID int, subscriberIDs []int) error
+
+	AllCampaigns() ([]models.Campaign, error)
+	GetCampaignByID(id int) (models.Campaign, error)
+	InsertCampaign(c models.Campaign) (int, error)
+	UpdateCampaign(c models.Campaign) error
+	DueCampaigns(now time.Time) ([]models.Campaign, error)
+	StartCampaign(id int) error
+	FinishCampaign(id int) error
+	PendingCampaignRecipients(campaignID, limit int) ([]models.CampaignRecipient, error)
+	UpdateCampaignRecipientStatus(id int, status, errorMessage string) error
+	QueueCampaignEmail(recipientID int, mail models.MailData) error
+	CampaignRecipientCounts(campaignID int) (map[string]int, error)
+
+	InsertOutboxEmail(m models.MailData) (int, error)
+	ClaimOutboxEmails(limit int) ([]models.OutboxEmail, error)
+	MarkOutboxEmailSent(id int) error
+	MarkOutboxEmailFailed(id int, errorMessage string, nextAttempt time.Time, dead bool) error
+	FilterOutboxEmails(status string, limit int) ([]models.OutboxEmail, error)
+	OutboxCounts() (map[string]int, error)
+	RetryOutboxEmail(id int) error
+
+	RecordContentView(contentType, contentID string, day time.Time) error
+	DashboardStats(weekStart time.Time, weeks int, now time.Time) (models.DashboardStats, error)
+
+	InsertAuditEvent(e models.AuditEvent) error
+	AuditEvents(f models.AuditFilter) ([]models.AuditEvent, error)
 }
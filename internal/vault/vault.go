@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//KeySize is the length of an AES-256 key in bytes
+const KeySize = 32
+
+//ErrUnknownKey is returned when data was sealed with a key the keyring does not have
+var ErrUnknownKey = errors.New("vault: unknown key version")
+
+//Keyring seals data with AES-GCM. It keeps the old keys so data sealed before a key rotation
+//can still be opened, and seals new data with the newest key
+type Keyring struct {
+	keys    map[int]cipher.AEAD
+	current int
+}
+
+//New returns a keyring for the keys by version. The highest version is used to seal
+func New(keys map[int][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("vault: no keys")
+	}
+
+	k := &Keyring{keys: make(map[int]cipher.AEAD)}
+	for version, key := range keys {
+		if version <= 0 {
+			return nil, fmt.Errorf("vault: key version %d must be positive", version)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("vault: key %d must be %d bytes", version, KeySize)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[version] = aead
+		if version > k.current {
+			k.current = version
+		}
+	}
+
+	return k, nil
+}
+
+//ParseKeys reads keys written as comma separated version:base64 pairs, like "1:...,2:..."
+func ParseKeys(s string) (map[int][]byte, error) {
+	keys := make(map[int][]byte)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("vault: %q is not version:key", pair)
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("vault: %q is not a key version", parts[0])
+		}
+		if _, ok := keys[version]; ok {
+			return nil, fmt.Errorf("vault: key version %d given twice", version)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("vault: key %d is not base64: %v", version, err)
+		}
+		keys[version] = key
+	}
+	return keys, nil
+}
+
+//NewKey returns a random key, base64 encoded the way ParseKeys reads it
+func NewKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+//Current is the version of the key new data is sealed with
+func (k *Keyring) Current() int {
+	return k.current
+}
+
+//Seal encrypts plaintext with the current key. The additional data is not stored but must be
+//given again to open the result, which ties the ciphertext to what it belongs to
+func (k *Keyring) Seal(plaintext, additionalData []byte) (int, []byte, error) {
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return 0, nil, err
+	}
+
+	return k.current, aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+//Open decrypts ciphertext sealed with the key of the given version
+func (k *Keyring) Open(version int, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[version]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("vault: ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+//Rotate opens ciphertext sealed with an older key and seals it again with the current key
+func (k *Keyring) Rotate(version int, ciphertext, additionalData []byte) (int, []byte, error) {
+	plaintext, err := k.Open(version, ciphertext, additionalData)
+	if err != nil {
+		return 0, nil, err
+	}
+	return k.Seal(plaintext, additionalData)
+}
@@ -0,0 +1,109 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, versions ...int) *Keyring {
+	keys := make(map[int][]byte)
+	for _, v := range versions {
+		keys[v] = bytes.Repeat([]byte{byte(v)}, KeySize)
+	}
+
+	k, err := New(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealAndOpen(t *testing.T) {
+	k := testKeyring(t, 1, 2)
+
+	version, sealed, err := k.Seal([]byte("client is doing better"), []byte("reservation:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("expected the newest key to seal, got version %d", version)
+	}
+	if bytes.Contains(sealed, []byte("better")) {
+		t.Error("expected the note to be encrypted")
+	}
+
+	opened, err := k.Open(version, sealed, []byte("reservation:1"))
+	if err != nil || string(opened) != "client is doing better" {
+		t.Errorf("expected the note back, got %q, %v", opened, err)
+	}
+
+	if _, err := k.Open(version, sealed, []byte("reservation:2")); err == nil {
+		t.Error("expected a note moved to another reservation not to open")
+	}
+
+	if _, err := k.Open(3, sealed, []byte("reservation:1")); err != ErrUnknownKey {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := testKeyring(t, 1)
+	_, sealed, err := old.Seal([]byte("note"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := testKeyring(t, 1, 2)
+	version, rotated, err := k.Rotate(1, sealed, nil)
+	if err != nil || version != 2 {
+		t.Fatalf("expected the note sealed with key 2, got %d, %v", version, err)
+	}
+
+	if _, err := testKeyring(t, 2).Open(version, rotated, nil); err != nil {
+		t.Errorf("expected the rotated note to open without the old key, got %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name  string
+		input string
+		keys  int
+		valid bool
+	}{
+		{"one key", "1:" + key, 1, true},
+		{"two keys", "1:" + key + ", 2:" + key, 2, true},
+		{"empty", "", 0, true},
+		{"missing version", key, 0, false},
+		{"bad version", "a:" + key, 0, false},
+		{"repeated version", "1:" + key + ",1:" + key, 0, false},
+		{"bad base64", "1:not base64!", 0, false},
+	}
+
+	for _, tt := range tests {
+		keys, err := ParseKeys(tt.input)
+		if tt.valid && (err != nil || len(keys) != tt.keys) {
+			t.Errorf("%s: expected %d keys, got %d, %v", tt.name, tt.keys, len(keys), err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("expected an error without keys")
+	}
+
+	_, err := New(map[int][]byte{1: []byte("short")})
+	if err == nil || !strings.Contains(err.Error(), "32 bytes") {
+		t.Errorf("expected a key length error, got %v", err)
+	}
+}